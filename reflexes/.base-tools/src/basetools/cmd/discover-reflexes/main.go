@@ -3,10 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"nhi/basetools/pkg/buildinfo"
 	"nhi/basetools/pkg/discoverytypes" // Import our new type
 	"nhi/basetools/pkg/manifesttypes" // Import existing manifest types
 	"os"
 	"path/filepath"
+	"sort"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,6 +20,10 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Println(buildinfo.String("discover-reflexes"))
+		return
+	}
 	reflexes, err := findAndParseReflexes(reflexRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error discovering reflexes: %v\n", err)
@@ -34,7 +40,7 @@ func main() {
 }
 
 // findAndParseReflexes walks the root directory, finds manifest.yml files,
-// parses them, and returns a slice of DiscoveredReflex structs.
+// parses them, and returns a slice of DiscoveredReflex structs sorted by path.
 func findAndParseReflexes(rootDir string) ([]discoverytypes.DiscoveredReflex, error) {
 	var discovered []discoverytypes.DiscoveredReflex
 
@@ -73,6 +79,10 @@ func findAndParseReflexes(rootDir string) ([]discoverytypes.DiscoveredReflex, er
 		return nil, fmt.Errorf("error walking the path %q: %w", rootDir, err)
 	}
 
+	// Walk visits entries in lexical order, but sort explicitly so the output
+	// stays stable whatever the traversal does
+	sort.Slice(discovered, func(i, j int) bool { return discovered[i].Path < discovered[j].Path })
+
 	return discovered, nil
 }
 
@@ -113,6 +123,15 @@ func parseManifest(manifestPath string, rootDir string) (discoverytypes.Discover
 		Inputs:      inputsMap,
 		Outputs:     outputsMap,
 	}
+	if manifest.Stdin != nil {
+		discovered.Stdin = manifest.Stdin
+	}
+	if len(manifest.Commands) > 0 {
+		discovered.Commands = make(map[string]interface{})
+		for k, v := range manifest.Commands {
+			discovered.Commands[k] = v
+		}
+	}
 
 	// Handle cases where maps might be empty after conversion
 	if len(discovered.Inputs) == 0 {
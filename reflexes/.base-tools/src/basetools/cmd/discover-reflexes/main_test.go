@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nhi/basetools/internal/testutil"
+)
+
+// The tree holds a reflex with several inputs, outputs and variables (the
+// shared fixture), a multi-command reflex and a manifest under bin/, which
+// is skipped.
+func TestFindAndParseReflexes(t *testing.T) {
+	root := t.TempDir()
+	for dst, src := range map[string]string{
+		"convert/images/manifest.yml": testutil.Fixture("manifest.yml"),
+		"generate/site/manifest.yml":  filepath.Join("testdata", "reflexes", "generate", "site", "manifest.yml"),
+		"bin/ignored/manifest.yml":    filepath.Join("testdata", "reflexes", "bin", "ignored", "manifest.yml"),
+	} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(root, dst)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reflexes, err := findAndParseReflexes(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(reflexes, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckGolden(t, filepath.Join("testdata", "discover.golden"), string(data)+"\n")
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nhi/basetools/pkg/buildinfo"
+)
+
+// Exit codes of the manifest command. Usage and input errors match the
+// entrypoint helper's.
+const (
+	exitOK      = 0
+	exitFailed  = 1  // verify, lint, test or fmt --check found problems, or the command failed
+	exitUsage   = 64 // Bad command line
+	exitNoInput = 66 // The manifest could not be read or parsed
+)
+
+// usageError is a bad command line.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+// inputError is a manifest that could not be read or parsed.
+type inputError struct{ err error }
+
+func (e inputError) Error() string { return e.err.Error() }
+
+// command is one manifest subcommand.
+type command struct {
+	Name    string
+	Summary string
+	Formats []string // Accepted --format values, the default first; nil if the command has no --format
+	Flags   func(fs *flag.FlagSet, h *ManifestHandler)
+}
+
+// commands lists the subcommands in the order help shows them.
+var commands = []command{
+	{Name: "show", Summary: "Render the manifest as documentation (human), its NHI specification (nhi) or JSON",
+		Formats: []string{"human", "nhi", "json"}},
+	{Name: "verify", Summary: "Check, without changing anything, that the current environment, mounts and image satisfy the manifest",
+		Formats: []string{"text", "json", "junit"},
+		Flags: func(fs *flag.FlagSet, h *ManifestHandler) {
+			fs.BoolVar(&h.Fix, "fix", false, "Create missing output directories and hand them to CALLING_UID/CALLING_GID before checking")
+		}},
+	{Name: "lint", Summary: "Check the manifest itself for unknown fields and invalid values",
+		Formats: []string{"text", "json", "junit"}},
+	{Name: "fmt", Summary: "Print the manifest in canonical YAML formatting",
+		Flags: func(fs *flag.FlagSet, h *ManifestHandler) {
+			fs.BoolVar(&h.Write, "w", false, "Rewrite the manifest in place")
+			fs.BoolVar(&h.Check, "check", false, "Only report whether the manifest is formatted; exit 1 if not")
+		}},
+	{Name: "test", Summary: "Run the examples of /manifest.yml through the entrypoint helper (inside the image)",
+		Formats: []string{"text", "tap", "json"}},
+	{Name: "version", Summary: "Print the basetools version"},
+}
+
+// findCommand returns the subcommand called name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// writeUsage lists the subcommands.
+func writeUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: manifest <command> [flags] [manifest.yml]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintln(w, "  help     Show help for a command")
+	fmt.Fprintln(w, "\nRun 'manifest help <command>' for the flags of a command.")
+	fmt.Fprintln(w, "\nExit codes: 0 success, 1 problems found or command failed, 64 usage error, 66 manifest unreadable.")
+	fmt.Fprintln(w, "MANIFEST_PATH, OUTPUT_FORMAT, OUTPUT_PATH and COMMAND set the defaults of the path, --format, --output and command.")
+}
+
+// newFlagSet defines the flags of c on a fresh handler, whose defaults come
+// from the legacy environment variables.
+func newFlagSet(c command) (*flag.FlagSet, *ManifestHandler) {
+	h := NewManifestHandler()
+	h.Command = c.Name
+	fs := flag.NewFlagSet("manifest "+c.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard) // Errors are reported as usage errors
+	if c.Formats != nil {
+		// OUTPUT_FORMAT only provides the default when this command accepts
+		// it, so a format set for show does not break verify
+		format := c.Formats[0]
+		for _, f := range c.Formats {
+			if strings.EqualFold(h.OutputFormat, f) {
+				format = f
+			}
+		}
+		fs.StringVar(&h.OutputFormat, "format", format, "Output format: "+strings.Join(c.Formats, ", "))
+	}
+	if c.Name != "version" {
+		output := h.OutputPath
+		if output == "" {
+			output = "-"
+		}
+		fs.StringVar(&h.OutputPath, "o", output, "Write the output to this file instead of stdout (-)")
+		fs.StringVar(&h.OutputPath, "output", output, "Same as -o")
+	}
+	if c.Flags != nil {
+		c.Flags(fs, h)
+	}
+	return fs, h
+}
+
+// writeCommandHelp describes one subcommand and its flags.
+func writeCommandHelp(w io.Writer, c command) {
+	fs, _ := newFlagSet(c)
+	if c.Name == "version" {
+		fmt.Fprintln(w, "Usage: manifest version")
+	} else {
+		fmt.Fprintf(w, "Usage: manifest %s [flags] [manifest.yml]\n", c.Name)
+	}
+	fmt.Fprintf(w, "\n%s.\n", c.Summary)
+	var hasFlags bool
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// parseArgs parses the flags and the optional manifest path of c. Flags may
+// come before or after the path.
+func parseArgs(c command, args []string) (*ManifestHandler, error) {
+	fs, h := newFlagSet(c)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	switch {
+	case c.Name == "version" && len(positional) > 0:
+		return nil, usageError{"manifest version takes no arguments"}
+	case len(positional) > 1:
+		return nil, usageError{fmt.Sprintf("expected at most one manifest path, got %d arguments", len(positional))}
+	case len(positional) == 1:
+		h.ManifestPath = positional[0]
+	}
+	if c.Name == "test" {
+		// The examples run through the helper, which only reads its manifest
+		if h.ManifestPath == "" {
+			h.ManifestPath = helperManifestPath
+		}
+		if abs, err := filepath.Abs(h.ManifestPath); err != nil || abs != helperManifestPath {
+			return nil, usageError{fmt.Sprintf("manifest test runs the examples through the entrypoint helper, which always reads %s; omit the manifest path", helperManifestPath)}
+		}
+	}
+	if c.Formats != nil {
+		valid := false
+		for _, f := range c.Formats {
+			valid = valid || strings.EqualFold(h.OutputFormat, f)
+		}
+		if !valid {
+			return nil, usageError{fmt.Sprintf("unsupported output format %q for %s; use %s", h.OutputFormat, c.Name, strings.Join(c.Formats, ", "))}
+		}
+	}
+	if h.Write && h.Check {
+		return nil, usageError{"-w and --check cannot be combined"}
+	}
+	return h, nil
+}
+
+// run executes the command line args (without the program name) and returns
+// the exit code. Without arguments, the command comes from COMMAND (default
+// show), as it did before the manifest command had subcommands.
+func run(args []string, stdout, stderr io.Writer) int {
+	name := os.Getenv("COMMAND")
+	if name == "" {
+		name = "show"
+	}
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	switch name {
+	case "-h", "--help", "help":
+		if len(args) > 0 {
+			c, ok := findCommand(args[0])
+			if !ok {
+				fmt.Fprintf(stderr, "Error: unknown command %q\n\n", args[0])
+				writeUsage(stderr)
+				return exitUsage
+			}
+			writeCommandHelp(stdout, c)
+			return exitOK
+		}
+		writeUsage(stdout)
+		return exitOK
+	case "--version":
+		name = "version"
+	}
+	c, ok := findCommand(strings.ToLower(name))
+	if !ok {
+		fmt.Fprintf(stderr, "Error: unknown command %q\n\n", name)
+		writeUsage(stderr)
+		return exitUsage
+	}
+
+	h, err := parseArgs(c, args)
+	if errors.Is(err, flag.ErrHelp) {
+		writeCommandHelp(stdout, c)
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n\n", err)
+		writeCommandHelp(stderr, c)
+		return exitUsage
+	}
+	if c.Name == "version" {
+		fmt.Fprintln(stdout, buildinfo.String("manifest"))
+		return exitOK
+	}
+
+	h.Stdout, h.Stderr = stdout, stderr
+	err = h.Process()
+	var inErr inputError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &inErr):
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitNoInput
+	default:
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
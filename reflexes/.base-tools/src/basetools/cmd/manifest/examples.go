@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nhi/basetools/pkg/examples"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Manifest the entrypoint helper reads, and so the only one test can run
+const helperManifestPath = "/manifest.yml"
+
+// Default entrypoint helper used to run examples, overridable via ENTRYPOINT_HELPER
+const defaultEntrypointHelper = "nhi-entrypoint-helper"
+
+// runExamples runs every example declared in the manifest through the
+// entrypoint helper, against fresh copies of its fixtures, and reports each
+// one as PASS or FAIL, or as TAP or JSON with OUTPUT_FORMAT. It is meant to
+// run inside the reflex image.
+func (h *ManifestHandler) runExamples(m manifesttypes.Manifest) error {
+	if len(m.Examples) == 0 {
+		return fmt.Errorf("manifest declares no examples")
+	}
+	if len(m.Command) == 0 && len(m.Commands) == 0 {
+		return fmt.Errorf("manifest has no command; set `command:` so its examples can be run")
+	}
+
+	helper := os.Getenv("ENTRYPOINT_HELPER")
+	if helper == "" {
+		helper = defaultEntrypointHelper
+	}
+	stderr := h.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	runner := examples.Runner{
+		Manifest: m,
+		BaseDir:  filepath.Dir(h.ManifestPath),
+		Command:  append([]string{helper}, m.Command...),
+		Stderr:   stderr,
+	}
+	results := runner.RunAll()
+
+	var sb strings.Builder
+	if err := examples.WriteReport(&sb, results, h.OutputFormat); err != nil {
+		return err
+	}
+	if err := h.writeOutput(sb.String()); err != nil {
+		return err
+	}
+
+	_, failed := examples.Summary(results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d examples failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// formatManifest rewrites the manifest in canonical YAML formatting: two-space
+// indentation and normalized spacing, keeping key order and comments. By
+// default the result is written to the output; -w rewrites the manifest and
+// --check only reports whether it is formatted.
+func (h *ManifestHandler) formatManifest(data []byte) error {
+	formatted, err := formatYAML(data)
+	if err != nil {
+		return inputError{fmt.Errorf("failed to parse manifest: %w", err)}
+	}
+	switch {
+	case h.Check:
+		if !bytes.Equal(data, formatted) {
+			return fmt.Errorf("%s is not formatted; run manifest fmt -w %s", h.ManifestPath, h.ManifestPath)
+		}
+		return nil
+	case h.Write:
+		if bytes.Equal(data, formatted) {
+			return nil
+		}
+		info, err := os.Stat(h.ManifestPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(h.ManifestPath, formatted, info.Mode().Perm())
+	default:
+		return h.writeOutput(string(formatted))
+	}
+}
+
+// formatYAML re-encodes a YAML document through its node tree. The encoder
+// drops blank lines and moves comments to the indentation of the key they
+// end up attached to, so the blank lines that separated mapping entries in
+// data are put back, and so is the deeper indentation of comments, such as
+// those under an empty mapping.
+func formatYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	var out yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &out); err != nil {
+		return nil, err
+	}
+	l := layout{blankBefore: make(map[int]bool), indent: make(map[int]int)}
+	l.restore(&doc, &out, strings.Split(string(data), "\n"))
+
+	var formatted bytes.Buffer
+	for i, line := range strings.SplitAfter(buf.String(), "\n") {
+		if l.blankBefore[i+1] {
+			formatted.WriteString("\n")
+		}
+		if n, ok := l.indent[i+1]; ok {
+			line = strings.Repeat(" ", n) + strings.TrimLeft(line, " ")
+		}
+		formatted.WriteString(line)
+	}
+	return formatted.Bytes(), nil
+}
+
+// layout records, by output line, what the encoder lost from the original.
+type layout struct {
+	blankBefore map[int]bool // Lines to precede with a blank line
+	indent      map[int]int  // Comment lines to indent by this many spaces
+}
+
+// restore walks the original and re-encoded trees in step. It records the
+// output line of every mapping entry that followed a blank line in the
+// original, and the indentation of head comment lines that were indented
+// deeper than their key. An entry starts at its head comment, if any.
+func (l layout) restore(orig, out *yaml.Node, lines []string) {
+	if orig == nil || out == nil || len(orig.Content) != len(out.Content) {
+		return
+	}
+	for i := range orig.Content {
+		if orig.Kind == yaml.MappingNode && i%2 == 0 {
+			origKey, outKey := orig.Content[i], out.Content[i]
+			start := entryStart(origKey)
+			if i > 0 && start >= 2 && strings.TrimSpace(lines[start-2]) == "" {
+				l.blankBefore[entryStart(outKey)] = true
+			}
+			for k := 0; start+k < origKey.Line; k++ {
+				line := lines[start+k-1]
+				comment := strings.TrimLeft(line, " ")
+				deeper := len(line) - len(comment) - (origKey.Column - 1)
+				if strings.HasPrefix(comment, "#") && deeper > 0 {
+					l.indent[entryStart(outKey)+k] = outKey.Column - 1 + deeper
+				}
+			}
+		}
+		l.restore(orig.Content[i], out.Content[i], lines)
+	}
+}
+
+// entryStart returns the first line of the mapping entry whose key is key.
+func entryStart(key *yaml.Node) int {
+	if key.HeadComment == "" {
+		return key.Line
+	}
+	return key.Line - strings.Count(key.HeadComment, "\n") - 1
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "indentation and spacing",
+			in:   "name:   demo\nenvironment:\n    GREETING:\n        type: string\n",
+			want: "name: demo\nenvironment:\n  GREETING:\n    type: string\n",
+		},
+		{
+			name: "blank lines between entries",
+			in:   "name: demo\n\n# Contract\nenvironment:\n  A:\n    type: string\n\n  B:\n    type: string\n",
+			want: "name: demo\n\n# Contract\nenvironment:\n  A:\n    type: string\n\n  B:\n    type: string\n",
+		},
+		{
+			name: "comments under an empty mapping keep their indentation",
+			in:   "environment:\n  # GREETING:\n  #   type: string\n\n# Checked first\nrequires:\n  files: [/app/run.sh]\n",
+			want: "environment:\n  # GREETING:\n  #   type: string\n\n# Checked first\nrequires:\n  files: [/app/run.sh]\n",
+		},
+		{
+			name: "missing final newline",
+			in:   "name: demo",
+			want: "name: demo\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("formatYAML() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every manifest in the repository is formatted, so manifest fmt --check
+// passes on it, and formatting is idempotent.
+func TestFormatRepositoryManifests(t *testing.T) {
+	root := filepath.Join("..", "..", "..", "..", "..") // reflexes/
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "vendor" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "manifest.yml" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no manifests under %s", root)
+	}
+	for _, path := range paths {
+		name, _ := filepath.Rel(root, path)
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once, err := formatYAML(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			twice, err := formatYAML(once)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(twice) != string(once) {
+				t.Errorf("formatting is not idempotent:\n--- once ---\n%s\n--- twice ---\n%s", once, twice)
+			}
+			if string(once) != string(data) {
+				t.Errorf("not formatted; run manifest fmt -w %s:\n%s", path, once)
+			}
+		})
+	}
+}
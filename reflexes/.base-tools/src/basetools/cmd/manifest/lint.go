@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"nhi/basetools/pkg/buildinfo"
+	"nhi/basetools/pkg/manifesttypes"
+	"nhi/basetools/pkg/requirements"
+)
+
+// Value types the tools understand; anything else is treated as a string
+var knownValueTypes = map[string]bool{
+	"": true, "string": true, "boolean": true, "bool": true, "integer": true, "int": true, "number": true, "float": true,
+}
+
+// Path types of input and output paths
+var knownPathTypes = map[string]bool{"": true, "file": true, "directory": true, "glob": true}
+
+// lintManifest checks the manifest itself, without looking at the
+// environment: unknown fields, which usually are typos or fields of a newer
+// toolchain, and values the tools would reject or misread at run time.
+func (h *ManifestHandler) lintManifest(data []byte, m manifesttypes.Manifest) error {
+	var problems []VerificationError
+	add := func(name, format string, args ...interface{}) {
+		problems = append(problems, VerificationError{
+			Type:        "lint",
+			Name:        name,
+			Description: fmt.Sprintf(format, args...),
+			Severity:    severityError,
+			Code:        "invalid_value",
+			Line:        h.lineOf(name),
+		})
+	}
+
+	// Unknown fields
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var strict manifesttypes.Manifest
+	if err := dec.Decode(&strict); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				problems = append(problems, unknownFieldProblem(msg))
+			}
+		} else {
+			add("manifest", "%v", err)
+		}
+	}
+
+	// Top-level fields
+	for field, value := range map[string]string{"name": m.Name, "version": m.Version, "description": m.Description} {
+		if strings.TrimSpace(value) == "" {
+			add(field, "Missing %s", field)
+		}
+	}
+	if m.RequiresBasetools != "" {
+		if err := buildinfo.ValidateConstraint(m.RequiresBasetools); err != nil {
+			add("requires_basetools", "%v", err)
+		}
+	}
+
+	// Contract sections, top-level and per subcommand
+	lintSections(add, "", m.Environment, m.InputPaths, m.OutputPaths, m.Outputs, m.Stdin, m.Stdout)
+	for _, name := range manifesttypes.SortedKeys(m.Commands) {
+		spec := m.Commands[name]
+		prefix := "commands." + name + "."
+		if len(spec.Command) == 0 {
+			add("commands."+name, "Subcommand has no command")
+		}
+		lintSections(add, prefix, spec.Environment, spec.InputPaths, spec.OutputPaths, spec.Outputs, spec.Stdin, spec.Stdout)
+	}
+
+	// Runtime
+	if m.Runtime != nil && m.Runtime.Timeout != "" {
+		if d, err := time.ParseDuration(m.Runtime.Timeout); err != nil || d <= 0 {
+			add("runtime.timeout", "Invalid duration %q; use e.g. 90s or 10m", m.Runtime.Timeout)
+		}
+	}
+
+	// Requirements
+	if m.Requires != nil {
+		for i, exe := range m.Requires.Executables {
+			name := fmt.Sprintf("requires.executables[%d]", i)
+			if exe.Name == "" {
+				add(name, "Executable has no name")
+			}
+			if exe.MinVersion != "" {
+				if _, err := requirements.CompareVersions(exe.MinVersion, "0"); err != nil {
+					add(name, "min_version: %v", err)
+				}
+			}
+			if exe.VersionPattern != "" {
+				if _, err := regexp.Compile(exe.VersionPattern); err != nil {
+					add(name, "Invalid version_pattern: %v", err)
+				}
+			}
+		}
+	}
+
+	// Hooks
+	if m.Hooks != nil {
+		for phase, hooks := range map[string][]manifesttypes.HookSpec{"pre": m.Hooks.Pre, "post": m.Hooks.Post} {
+			for i, hook := range hooks {
+				name := fmt.Sprintf("hooks.%s[%d]", phase, i)
+				if len(hook.Command) == 0 {
+					add(name, "Hook has no command")
+				}
+				switch strings.ToLower(hook.OnFailure) {
+				case "", manifesttypes.HookAbort, manifesttypes.HookFail, manifesttypes.HookIgnore:
+				default:
+					add(name, "Invalid on_failure %q; use abort, fail or ignore", hook.OnFailure)
+				}
+				if hook.Timeout != "" {
+					if d, err := time.ParseDuration(hook.Timeout); err != nil || d <= 0 {
+						add(name, "Invalid timeout %q; use e.g. 90s or 10m", hook.Timeout)
+					}
+				}
+			}
+		}
+	}
+
+	// Examples
+	if len(m.Examples) > 0 && len(m.Command) == 0 && len(m.Commands) == 0 {
+		add("examples", "Examples need a command; set `command:` or `commands:`")
+	}
+	seen := make(map[string]bool)
+	for i, ex := range m.Examples {
+		name := fmt.Sprintf("examples[%d]", i)
+		switch {
+		case ex.Name == "":
+			add(name, "Example has no name")
+		case seen[ex.Name]:
+			add(name, "Duplicate example name %q", ex.Name)
+		}
+		seen[ex.Name] = true
+		if ex.Command != "" {
+			if _, ok := m.Commands[ex.Command]; !ok {
+				add(name, "Unknown subcommand %q", ex.Command)
+			}
+		}
+	}
+
+	sortProblems(problems)
+	r := h.newReport(problems)
+	if err := h.writeReport(r, lintText); err != nil {
+		return err
+	}
+	if !r.Passed {
+		return fmt.Errorf("%d problems found in %s", r.Errors, h.ManifestPath)
+	}
+	return nil
+}
+
+// lintText renders a lint report as text.
+func lintText(r verificationReport) string {
+	if len(r.Problems) == 0 {
+		return "✓ Manifest is valid\n"
+	}
+	var out strings.Builder
+	out.WriteString("❌ Manifest has problems:\n\n")
+	for _, p := range r.Problems {
+		out.WriteString(problemLine(p))
+	}
+	return out.String()
+}
+
+// Line prefix of the decoder's messages, e.g. "line 12: field foo not found"
+var decoderLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// unknownFieldProblem turns a message of the strict decoder into a problem
+// located at the line it names.
+func unknownFieldProblem(msg string) VerificationError {
+	p := VerificationError{Type: "lint", Name: "manifest", Description: msg, Severity: severityError, Code: "unknown_field"}
+	if m := decoderLinePattern.FindStringSubmatch(msg); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+		p.Description = m[2]
+	}
+	return p
+}
+
+// lintSections checks the environment, paths and scalar outputs of the
+// manifest or of one subcommand; prefix locates them in the manifest.
+func lintSections(add func(name, format string, args ...interface{}), prefix string,
+	env map[string]manifesttypes.InputSpec, inputs, outputs map[string]manifesttypes.PathSpec,
+	scalars map[string]manifesttypes.InputSpec, stdin, stdout *manifesttypes.PathSpec) {
+	for _, name := range manifesttypes.SortedKeys(env) {
+		spec := env[name]
+		at := prefix + "environment." + name
+		lintValueSpec(add, at, spec)
+		if spec.Default != "" {
+			if err := spec.ValidateValue(spec.Default); err != nil {
+				add(at, "Default does not pass its own checks: %v", err)
+			}
+		}
+	}
+	for _, name := range manifesttypes.SortedKeys(scalars) {
+		lintValueSpec(add, prefix+"outputs."+name, scalars[name])
+	}
+	for section, paths := range map[string]map[string]manifesttypes.PathSpec{"input_paths": inputs, "output_paths": outputs} {
+		for _, name := range manifesttypes.SortedKeys(paths) {
+			spec := paths[name]
+			at := prefix + section + "." + name
+			if !knownPathTypes[strings.ToLower(spec.Type)] {
+				add(at, "Unknown type %q; use file, directory or glob", spec.Type)
+			}
+			if spec.Pattern != "" {
+				if _, err := filepath.Match(spec.Pattern, ""); err != nil {
+					add(at, "Invalid pattern %q: %v", spec.Pattern, err)
+				}
+			}
+			switch spec.Staging {
+			case "", manifesttypes.StagingNone, manifesttypes.StagingCopy:
+			default:
+				add(at, "Invalid staging %q; use none or copy", spec.Staging)
+			}
+		}
+	}
+	if stdin != nil && stdin.Staging != "" {
+		add(prefix+"stdin", "staging does not apply to stdin")
+	}
+	if stdout != nil && stdout.MaxSize != "" {
+		add(prefix+"stdout", "max_size only applies to stdin")
+	}
+}
+
+// lintValueSpec checks the type and pattern of an environment variable or
+// scalar output.
+func lintValueSpec(add func(name, format string, args ...interface{}), at string, spec manifesttypes.InputSpec) {
+	if !knownValueTypes[strings.ToLower(spec.Type)] {
+		add(at, "Unknown type %q; use string, boolean, integer or number", spec.Type)
+	}
+	if spec.Pattern != "" {
+		if _, err := filepath.Match(spec.Pattern, ""); err != nil {
+			add(at, "Invalid pattern %q: %v", spec.Pattern, err)
+		}
+	}
+}
+
+// sortProblems orders problems by location, so map iteration does not change
+// the report from run to run. The unknown-field errors of the decoder, named
+// "manifest", come first, in line order.
+func sortProblems(problems []VerificationError) {
+	rank := func(p VerificationError) int {
+		if p.Name == "manifest" {
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if ri, rj := rank(problems[i]), rank(problems[j]); ri != rj {
+			return ri < rj
+		}
+		if problems[i].Name == "manifest" {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Name < problems[j].Name
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,18 +19,40 @@ type ManifestHandler struct {
 	ManifestPath string
 	OutputFormat string
 	OutputPath   string
-	Command      string // "show" or "verify"
+	Command      string // "show", "verify", "lint", "fmt" or "test"
+	Write        bool   // fmt: rewrite the manifest in place
+	Check        bool   // fmt: only report whether the manifest is formatted
+	Fix          bool   // verify: create missing output directories and hand them to the calling user
+
+	Stdout io.Writer // Receives the output without -o; defaults to os.Stdout
+	Stderr io.Writer // test: receives the reflex's stderr; defaults to os.Stderr
+
+	fixes  []string   // verify: changes made by Fix, for the report
+	source *yaml.Node // The parsed manifest document, to locate entries by line
 }
 
-// VerificationError represents an error found during state verification
+// Severities of a VerificationError. Only errors fail verify and lint.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// VerificationError represents a problem found during state verification or
+// linting
 type VerificationError struct {
-	Type        string // "environment", "input_path", "output_path", "permission"
-	Name        string // Name of the variable/path
-	Description string // Description of the error
+	Type        string `json:"type"`           // "environment", "input_path", "output_path", "permission", "requirement" or "lint"
+	Name        string `json:"name"`           // Name of the variable/path
+	Description string `json:"description"`    // Description of the error
+	Severity    string `json:"severity"`       // "error" or "warning"
+	Code        string `json:"code"`           // Stable identifier of the kind of problem, e.g. "missing_env"
+	Line        int    `json:"line,omitempty"` // Line of the manifest entry concerned; 0 when none applies
 }
 
 // --- Shared Structs are now imported from nhi/basetools/pkg/manifesttypes ---
 
+// NewManifestHandler returns a handler configured from the environment
+// variables that drove the manifest command before it had subcommands. They
+// now provide the defaults of its flags.
 func NewManifestHandler() *ManifestHandler {
 	return &ManifestHandler{
 		ManifestPath: os.Getenv("MANIFEST_PATH"),
@@ -39,8 +62,7 @@ func NewManifestHandler() *ManifestHandler {
 	}
 }
 
-// ... (rest of the functions remain the same, using manifesttypes.Manifest etc.) ...
-
+// Process runs the handler's command on its manifest.
 func (h *ManifestHandler) Process() error {
 	// Default to manifest.yml in the current directory if not specified
 	if h.ManifestPath == "" {
@@ -50,19 +72,31 @@ func (h *ManifestHandler) Process() error {
 	// Read manifest file
 	data, err := os.ReadFile(h.ManifestPath)
 	if err != nil {
-		return fmt.Errorf("failed to read manifest: %w", err)
+		return inputError{fmt.Errorf("failed to read manifest: %w", err)}
 	}
 
-	// Parse manifest
+	// Parse manifest, keeping the document so problems can be located
 	var manifest manifesttypes.Manifest // Use imported type
-	if err := yaml.Unmarshal(data, &manifest); err != nil {
-		return fmt.Errorf("failed to parse manifest: %w", err)
+	h.source = new(yaml.Node)
+	if err := yaml.Unmarshal(data, h.source); err != nil {
+		return inputError{fmt.Errorf("failed to parse manifest: %w", err)}
+	}
+	if h.source.Kind != 0 { // An empty file is an empty manifest
+		if err := h.source.Decode(&manifest); err != nil {
+			return inputError{fmt.Errorf("failed to parse manifest: %w", err)}
+		}
 	}
 
 	// Process based on command
 	switch strings.ToLower(h.Command) {
 	case "verify":
 		return h.verifyState(manifest)
+	case "lint":
+		return h.lintManifest(data, manifest)
+	case "fmt":
+		return h.formatManifest(data)
+	case "test":
+		return h.runExamples(manifest)
 	default: // "show" is the default command
 		return h.showManifest(manifest)
 	}
@@ -71,7 +105,7 @@ func (h *ManifestHandler) Process() error {
 func (h *ManifestHandler) showManifest(m manifesttypes.Manifest) error {
 	// Process based on output format
 	switch strings.ToLower(h.OutputFormat) {
-	case "human":
+	case "", "human":
 		return h.outputHuman(m)
 	case "nhi":
 		return h.outputNHI(m)
@@ -82,168 +116,54 @@ func (h *ManifestHandler) showManifest(m manifesttypes.Manifest) error {
 	}
 }
 
-func (h *ManifestHandler) verifyState(m manifesttypes.Manifest) error {
-	var errors []VerificationError
-
-	// Verify environment variables
-	errors = append(errors, h.verifyEnvironment(m.Environment)...)
-
-	// Verify input paths
-	errors = append(errors, h.verifyInputPaths(m.InputPaths)...)
-
-	// Verify output paths and permissions
-	outputErrors := h.verifyOutputs(m.Stdout, m.OutputPaths)
-	errors = append(errors, outputErrors...)
-
-	// If there are errors, format and output them
-	if len(errors) > 0 {
-		return h.outputVerificationErrors(errors)
-	}
-
-	fmt.Println("✓ All manifest requirements satisfied")
-	return nil
-}
-
-func (h *ManifestHandler) verifyEnvironment(envVars map[string]manifesttypes.InputSpec) []VerificationError {
-	var errors []VerificationError
-
-	for name, spec := range envVars {
-		value := os.Getenv(name)
+// outputHuman renders the manifest as markdown. Sections are listed by name,
+// so the same manifest always renders the same way.
+func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
+	var sb strings.Builder
 
-		if spec.Required && value == "" {
-			errors = append(errors, VerificationError{
-				Type:        "environment",
-				Name:        name,
-				Description: "Required environment variable is not set",
-			})
-			continue
-		}
+	sb.WriteString(fmt.Sprintf("# %s (v%s)\n\n", m.Name, m.Version))
+	sb.WriteString(m.Description + "\n\n")
 
-		if value != "" && spec.Pattern != "" {
-			matched, err := filepath.Match(spec.Pattern, value)
-			if err != nil {
-				errors = append(errors, VerificationError{
-					Type:        "environment",
-					Name:        name,
-					Description: fmt.Sprintf("Invalid pattern in manifest: %v", err),
-				})
-			} else if !matched {
-				errors = append(errors, VerificationError{
-					Type:        "environment",
-					Name:        name,
-					Description: fmt.Sprintf("Value does not match required pattern: %s", spec.Pattern),
-				})
+	if len(m.Commands) > 0 {
+		sb.WriteString("## Subcommands\n\n")
+		sb.WriteString(fmt.Sprintf("Selected by the first argument or %s. Inputs and outputs below are shared by all subcommands.\n\n", manifesttypes.CommandEnv))
+		for _, name := range manifesttypes.SortedKeys(m.Commands) {
+			spec := m.Commands[name]
+			sb.WriteString(fmt.Sprintf("### %s\n", name))
+			if spec.Description != "" {
+				sb.WriteString(strings.TrimSpace(spec.Description) + "\n")
 			}
-		}
-	}
-
-	return errors
-}
-
-func (h *ManifestHandler) verifyInputPaths(paths map[string]manifesttypes.PathSpec) []VerificationError {
-	var errors []VerificationError
-
-	for name, spec := range paths {
-		if !spec.Required {
-			continue
-		}
-
-		matches, err := filepath.Glob(name)
-		if err != nil {
-			errors = append(errors, VerificationError{
-				Type:        "input_path",
-				Name:        name,
-				Description: fmt.Sprintf("Invalid path pattern: %v", err),
-			})
-			continue
-		}
-
-		if len(matches) == 0 {
-			errors = append(errors, VerificationError{
-				Type:        "input_path",
-				Name:        name,
-				Description: "Required input path not found",
-			})
-		}
-	}
-
-	return errors
-}
-
-func (h *ManifestHandler) verifyOutputs(stdout *manifesttypes.PathSpec, outputs map[string]manifesttypes.PathSpec) []VerificationError {
-	var errors []VerificationError
-
-	// If there are any output paths, verify CALLING_UID/GID are set
-	if len(outputs) > 0 {
-		if os.Getenv("CALLING_UID") == "" || os.Getenv("CALLING_GID") == "" {
-			errors = append(errors, VerificationError{
-				Type:        "permission",
-				Name:        "CALLING_UID/GID",
-				Description: "CALLING_UID and CALLING_GID must be set when output paths are specified",
-			})
-		}
-	}
-
-	// Verify output paths exist and are writable
-	for path := range outputs {
-		dir := filepath.Dir(path)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			errors = append(errors, VerificationError{
-				Type:        "output_path",
-				Name:        path,
-				Description: fmt.Sprintf("Cannot create output directory: %v", err),
-			})
-		}
-	}
-
-	return errors
-}
-
-func (h *ManifestHandler) outputVerificationErrors(errors []VerificationError) error {
-	// Group errors by type
-	grouped := make(map[string][]VerificationError)
-	for _, err := range errors {
-		grouped[err.Type] = append(grouped[err.Type], err)
-	}
-
-	// Format error output
-	var output strings.Builder
-	output.WriteString("❌ Manifest requirements not satisfied:\n\n")
-
-	for _, errType := range []string{"environment", "input_path", "output_path", "permission"} {
-		if errs, ok := grouped[errType]; ok {
-			switch errType {
-			case "environment":
-				output.WriteString("Environment Variables:\n")
-			case "input_path":
-				output.WriteString("Input Paths:\n")
-			case "output_path":
-				output.WriteString("Output Paths:\n")
-			case "permission":
-				output.WriteString("Permissions:\n")
+			sb.WriteString(fmt.Sprintf("- Command: `%s`\n", strings.Join(spec.Command, " ")))
+			sections := []struct {
+				title string
+				names []string
+			}{
+				{"Environment", manifesttypes.SortedKeys(spec.Environment)},
+				{"Input paths", manifesttypes.SortedKeys(spec.InputPaths)},
+				{"Output paths", manifesttypes.SortedKeys(spec.OutputPaths)},
+				{"Scalar outputs", manifesttypes.SortedKeys(spec.Outputs)},
 			}
-
-			for _, err := range errs {
-				output.WriteString(fmt.Sprintf("  - %s: %s\n", err.Name, err.Description))
+			for _, sec := range sections {
+				if len(sec.names) > 0 {
+					sb.WriteString(fmt.Sprintf("- %s: %s\n", sec.title, strings.Join(sec.names, ", ")))
+				}
+			}
+			if spec.Stdin != nil {
+				sb.WriteString(fmt.Sprintf("- Stdin: %s\n", strings.Join(nonEmptyStrings(spec.Stdin.Type, spec.Stdin.Format), ", ")))
 			}
-			output.WriteString("\n")
+			if spec.Stdout != nil {
+				sb.WriteString(fmt.Sprintf("- Stdout: %s\n", strings.Join(nonEmptyStrings(spec.Stdout.Type, spec.Stdout.Format), ", ")))
+			}
+			sb.WriteString("\n")
 		}
 	}
 
-	return h.writeOutput(output.String())
-}
-
-func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
-	var sb strings.Builder
-
-	sb.WriteString(fmt.Sprintf("# %s (v%s)\n\n", m.Name, m.Version))
-	sb.WriteString(m.Description + "\n\n")
-
 	// Inputs
 	sb.WriteString("## Inputs\n\n")
 	if len(m.Environment) > 0 {
 		sb.WriteString("### Environment Variables\n")
-		for name, spec := range m.Environment {
+		for _, name := range manifesttypes.SortedKeys(m.Environment) {
+			spec := m.Environment[name]
 			req := ""
 			if spec.Required {
 				req = " (Required)"
@@ -255,7 +175,8 @@ func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
 
 	if len(m.InputPaths) > 0 {
 		sb.WriteString("### Input Paths\n")
-		for path, spec := range m.InputPaths {
+		for _, path := range manifesttypes.SortedKeys(m.InputPaths) {
+			spec := m.InputPaths[path]
 			req := ""
 			if spec.Required {
 				req = " (Required)"
@@ -273,6 +194,23 @@ func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
 		sb.WriteString("\n")
 	}
 
+	if m.Stdin != nil {
+		sb.WriteString("### Standard Input\n")
+		req := ""
+		if m.Stdin.Required {
+			req = " (Required)"
+		}
+		details := nonEmptyStrings(m.Stdin.Type, m.Stdin.Format)
+		if m.Stdin.MaxSize != "" {
+			details = append(details, fmt.Sprintf("max size: %s", m.Stdin.MaxSize))
+		}
+		sb.WriteString(fmt.Sprintf("Format: %s%s\n", strings.Join(details, ", "), req))
+		if m.Stdin.Description != "" {
+			sb.WriteString(m.Stdin.Description + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
 	// Outputs
 	sb.WriteString("## Outputs\n\n")
 	if m.Stdout != nil {
@@ -286,7 +224,8 @@ func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
 
 	if len(m.OutputPaths) > 0 {
 		sb.WriteString("### Output Paths\n")
-		for path, spec := range m.OutputPaths {
+		for _, path := range manifesttypes.SortedKeys(m.OutputPaths) {
+			spec := m.OutputPaths[path]
 			details := []string{spec.Type}
 			if spec.Format != "" {
 				details = append(details, spec.Format)
@@ -297,6 +236,37 @@ func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
 			sb.WriteString(fmt.Sprintf("- %s (%s): %s\n",
 				path, strings.Join(details, ", "), spec.Description))
 		}
+		sb.WriteString("\n")
+	}
+
+	if len(m.Outputs) > 0 {
+		sb.WriteString("### Scalar Outputs\n")
+		for _, name := range manifesttypes.SortedKeys(m.Outputs) {
+			spec := m.Outputs[name]
+			req := ""
+			if spec.Required {
+				req = " (Required)"
+			}
+			typ := spec.Type
+			if typ == "" {
+				typ = "string"
+			}
+			sb.WriteString(fmt.Sprintf("- %s%s (%s): %s\n", name, req, typ, spec.Description))
+		}
+	}
+
+	if m.Requires != nil && (len(m.Requires.Executables) > 0 || len(m.Requires.Files) > 0) {
+		sb.WriteString("\n## Requirements\n\n")
+		for _, exe := range m.Requires.Executables {
+			if exe.MinVersion != "" {
+				sb.WriteString(fmt.Sprintf("- %s >= %s\n", exe.Name, exe.MinVersion))
+			} else {
+				sb.WriteString(fmt.Sprintf("- %s\n", exe.Name))
+			}
+		}
+		for _, path := range m.Requires.Files {
+			sb.WriteString(fmt.Sprintf("- %s\n", path))
+		}
 	}
 
 	return h.writeOutput(sb.String())
@@ -305,15 +275,21 @@ func (h *ManifestHandler) outputHuman(m manifesttypes.Manifest) error {
 func (h *ManifestHandler) outputNHI(m manifesttypes.Manifest) error {
 	// Output just the NHI-compatible specification section
 	nhiSpec := struct {
-		Environment map[string]manifesttypes.InputSpec `json:"environment"`
-		InputPaths  map[string]manifesttypes.PathSpec `json:"input_paths,omitempty"`
-		Stdout     *manifesttypes.PathSpec           `json:"stdout,omitempty"`
-		OutputPaths map[string]manifesttypes.PathSpec `json:"output_paths,omitempty"`
+		Environment map[string]manifesttypes.InputSpec   `json:"environment"`
+		InputPaths  map[string]manifesttypes.PathSpec    `json:"input_paths,omitempty"`
+		Stdin       *manifesttypes.PathSpec              `json:"stdin,omitempty"`
+		Stdout      *manifesttypes.PathSpec              `json:"stdout,omitempty"`
+		OutputPaths map[string]manifesttypes.PathSpec    `json:"output_paths,omitempty"`
+		Outputs     map[string]manifesttypes.InputSpec   `json:"outputs,omitempty"`
+		Commands    map[string]manifesttypes.CommandSpec `json:"commands,omitempty"`
 	}{
 		Environment: m.Environment,
 		InputPaths:  m.InputPaths,
-		Stdout:     m.Stdout,
+		Stdin:       m.Stdin,
+		Stdout:      m.Stdout,
 		OutputPaths: m.OutputPaths,
+		Outputs:     m.Outputs,
+		Commands:    m.Commands,
 	}
 
 	data, err := yaml.Marshal(nhiSpec)
@@ -335,7 +311,11 @@ func (h *ManifestHandler) outputJSON(m manifesttypes.Manifest) error {
 
 func (h *ManifestHandler) writeOutput(content string) error {
 	if h.OutputPath == "" || h.OutputPath == "-" {
-		_, err := fmt.Print(content)
+		w := h.Stdout
+		if w == nil {
+			w = os.Stdout
+		}
+		_, err := io.WriteString(w, content)
 		return err
 	}
 
@@ -346,10 +326,13 @@ func (h *ManifestHandler) writeOutput(content string) error {
 	return os.WriteFile(h.OutputPath, []byte(content), 0644)
 }
 
-func main() {
-	handler := NewManifestHandler()
-	if err := handler.Process(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+// nonEmptyStrings returns the non-empty values, in order.
+func nonEmptyStrings(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
 	}
-}
\ No newline at end of file
+	return out
+}
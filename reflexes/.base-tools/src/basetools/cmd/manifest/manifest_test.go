@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nhi/basetools/internal/testutil"
+)
+
+func TestShowManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		golden string
+	}{
+		{name: "human", format: "human", golden: "show"},
+		{name: "default format is human", format: "", golden: "show"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			h := &ManifestHandler{ManifestPath: testutil.Fixture("manifest.yml"), OutputFormat: tt.format, OutputPath: out, Command: "show"}
+			if err := h.Process(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckGolden(t, filepath.Join("testdata", tt.golden+".golden"), string(got))
+		})
+	}
+}
+
+// Without -o, output goes to the writer run was given.
+func TestRunWritesToStdout(t *testing.T) {
+	var stdout strings.Builder
+	if code := run([]string{"show", testutil.Fixture("manifest.yml")}, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	testutil.CheckGolden(t, filepath.Join("testdata", "show.golden"), stdout.String())
+}
+
+// test runs the examples through the helper, which only reads /manifest.yml.
+func TestParseArgsTestManifestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantPath string // Empty when the arguments are rejected
+	}{
+		{name: "default", wantPath: "/manifest.yml"},
+		{name: "helper manifest", args: []string{"/manifest.yml"}, wantPath: "/manifest.yml"},
+		{name: "other manifest", args: []string{testutil.Fixture("manifest.yml")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MANIFEST_PATH", "")
+			c, _ := findCommand("test")
+			h, err := parseArgs(c, tt.args)
+			switch {
+			case tt.wantPath == "" && err == nil:
+				t.Fatalf("parseArgs() accepted %s", h.ManifestPath)
+			case tt.wantPath == "":
+			case err != nil:
+				t.Fatal(err)
+			case h.ManifestPath != tt.wantPath:
+				t.Fatalf("ManifestPath = %s, want %s", h.ManifestPath, tt.wantPath)
+			}
+		})
+	}
+}
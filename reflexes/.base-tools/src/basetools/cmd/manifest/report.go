@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// verificationReport is the outcome of verify or lint, as rendered by
+// --format json and junit.
+type verificationReport struct {
+	Command  string              `json:"command"` // "verify" or "lint"
+	Manifest string              `json:"manifest"`
+	Passed   bool                `json:"passed"` // No problem is an error
+	Errors   int                 `json:"errors"`
+	Warnings int                 `json:"warnings"`
+	Fixes    []string            `json:"fixes,omitempty"` // Changes made by verify --fix
+	Problems []VerificationError `json:"problems"`
+}
+
+// newReport counts the problems by severity. Problems without a severity are
+// errors.
+func (h *ManifestHandler) newReport(problems []VerificationError) verificationReport {
+	r := verificationReport{Command: h.Command, Manifest: h.ManifestPath, Fixes: h.fixes, Problems: problems}
+	if r.Problems == nil {
+		r.Problems = []VerificationError{}
+	}
+	for i := range r.Problems {
+		if r.Problems[i].Severity == "" {
+			r.Problems[i].Severity = severityError
+		}
+		if r.Problems[i].Severity == severityError {
+			r.Errors++
+		} else {
+			r.Warnings++
+		}
+	}
+	r.Passed = r.Errors == 0
+	return r
+}
+
+// writeReport writes r in the handler's output format; text renders the
+// text format.
+func (h *ManifestHandler) writeReport(r verificationReport, text func(verificationReport) string) error {
+	switch strings.ToLower(h.OutputFormat) {
+	case "", "text":
+		return h.writeOutput(text(r))
+	case "json":
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		return h.writeOutput(string(data) + "\n")
+	case "junit":
+		out, err := junitReport(r)
+		if err != nil {
+			return err
+		}
+		return h.writeOutput(out)
+	default:
+		return fmt.Errorf("unsupported output format: %s", h.OutputFormat)
+	}
+}
+
+// JUnit XML, in the dialect most CI systems read
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Type    string `xml:"type,attr,omitempty"`
+	Message string `xml:"message,attr"`
+}
+
+// junitReport renders r as one test suite with a test case per problem:
+// errors are failures and warnings are skipped tests. A passing run has a
+// single passing test case, so CI still records it.
+func junitReport(r verificationReport) (string, error) {
+	suite := junitTestSuite{Name: "manifest " + r.Command + " " + r.Manifest, Failures: r.Errors, Skipped: r.Warnings}
+	for _, p := range r.Problems {
+		tc := junitTestCase{ClassName: r.Command, Name: p.Name, File: r.Manifest, Line: p.Line}
+		if p.Type != r.Command {
+			tc.ClassName += "." + p.Type
+		}
+		msg := &junitMessage{Type: p.Code, Message: p.Description}
+		if p.Severity == severityError {
+			tc.Failure = msg
+		} else {
+			tc.Skipped = msg
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if r.Errors == 0 {
+		suite.Cases = append(suite.Cases, junitTestCase{ClassName: r.Command, Name: r.Manifest, File: r.Manifest})
+	}
+	suite.Tests = len(suite.Cases)
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JUnit report: %w", err)
+	}
+	return xml.Header + string(data) + "\n", nil
+}
+
+// line returns the line of the manifest entry at path, one key or index per
+// element, e.g. ("output_paths", "site"). When part of the path is missing,
+// the line of the deepest entry found is returned; 0 when nothing is found.
+func (h *ManifestHandler) line(path ...string) int {
+	if h.source == nil || len(h.source.Content) == 0 {
+		return 0
+	}
+	node, line := h.source.Content[0], 0
+	for _, key := range path {
+		next, at := child(node, key)
+		if next == nil {
+			break
+		}
+		node, line = next, at
+	}
+	return line
+}
+
+// child returns the value under key in a mapping, or at index key in a
+// sequence, with the line where it is declared.
+func child(node *yaml.Node, key string) (*yaml.Node, int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1], node.Content[i].Line
+			}
+		}
+	case yaml.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node.Content) {
+			return node.Content[i], node.Content[i].Line
+		}
+	}
+	return nil, 0
+}
+
+// Index suffix of a lint location, e.g. "[2]" in "examples[2]"
+var indexPattern = regexp.MustCompile(`^(.*)\[(\d+)\]$`)
+
+// lineOf returns the line of a lint location such as
+// "commands.build.environment.FOO" or "hooks.pre[1]". Keys may themselves
+// contain dots, so the longest key that exists wins at each level.
+func (h *ManifestHandler) lineOf(location string) int {
+	if h.source == nil || len(h.source.Content) == 0 {
+		return 0
+	}
+	node, line := h.source.Content[0], 0
+	parts := strings.Split(location, ".")
+	for len(parts) > 0 {
+		found := false
+		for n := len(parts); n > 0 && !found; n-- {
+			key, index := strings.Join(parts[:n], "."), ""
+			if m := indexPattern.FindStringSubmatch(key); m != nil {
+				key, index = m[1], m[2]
+			}
+			next, at := child(node, key)
+			if next == nil {
+				continue
+			}
+			if index != "" {
+				if item, itemAt := child(next, index); item != nil {
+					next, at = item, itemAt
+				}
+			}
+			node, line, parts, found = next, at, parts[n:], true
+		}
+		if !found {
+			break
+		}
+	}
+	return line
+}
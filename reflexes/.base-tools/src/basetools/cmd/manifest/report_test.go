@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A manifest whose verify fails only on requirements: the second executable
+// and the first file are missing.
+const requirementsManifest = `name: needs-tools
+version: "1.0"
+description: "Test reflex"
+requires:
+  executables:
+    - name: sh
+    - name: nhi-test-missing-tool
+  files:
+    - /nhi-test-missing-file
+    - /
+`
+
+// runVerify runs manifest verify on content with args and returns the exit
+// code and the report it wrote.
+func runVerify(t *testing.T, content string, args ...string) (int, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "report")
+	code := run(append(append([]string{"verify", "-o", out}, args...), path), io.Discard, io.Discard)
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("no report written (exit code %d): %v", code, err)
+	}
+	return code, data
+}
+
+func TestVerifyJSONReport(t *testing.T) {
+	code, data := runVerify(t, requirementsManifest, "--format", "json")
+	if code != exitFailed {
+		t.Errorf("exit code %d, want %d", code, exitFailed)
+	}
+	var r verificationReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("report is not JSON: %v\n%s", err, data)
+	}
+	if r.Command != "verify" || r.Passed || r.Errors != 2 || r.Warnings != 0 {
+		t.Errorf("report header = %+v, want a failed verify with 2 errors", r)
+	}
+	want := []struct {
+		name string
+		line int
+	}{
+		{name: "nhi-test-missing-tool", line: 7},
+		{name: "/nhi-test-missing-file", line: 9},
+	}
+	if len(r.Problems) != len(want) {
+		t.Fatalf("problems = %+v, want %d", r.Problems, len(want))
+	}
+	for i, w := range want {
+		p := r.Problems[i]
+		if p.Name != w.name || p.Line != w.line || p.Code != "missing_requirement" || p.Severity != severityError {
+			t.Errorf("problem %d = %+v, want %s at line %d", i, p, w.name, w.line)
+		}
+	}
+}
+
+func TestVerifyPasses(t *testing.T) {
+	code, data := runVerify(t, "name: ok\nversion: \"1.0\"\ndescription: \"Test reflex\"\n", "--format", "json")
+	if code != exitOK {
+		t.Errorf("exit code %d, want %d", code, exitOK)
+	}
+	var r verificationReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Passed || r.Problems == nil || len(r.Problems) != 0 {
+		t.Errorf("report = %+v, want a pass with an empty problem list", r)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	tests := []struct {
+		name         string
+		problems     []VerificationError
+		wantTests    int
+		wantFailures int
+		wantSkipped  int
+		wantClasses  []string
+	}{
+		{
+			name: "errors fail and warnings are skipped",
+			problems: []VerificationError{
+				{Type: "requirement", Name: "jekyll", Description: "not found", Code: "missing_requirement", Line: 4},
+				{Type: "output_path", Name: "site", Description: "missing", Code: "missing_optional_output", Severity: severityWarning},
+				{Type: "verify", Name: "x", Description: "y", Code: "z"},
+			},
+			wantTests: 3, wantFailures: 2, wantSkipped: 1,
+			wantClasses: []string{"verify.requirement", "verify.output_path", "verify"},
+		},
+		{name: "a pass records one passing case", wantTests: 1, wantClasses: []string{"verify"}},
+		{
+			name:      "warnings only still pass",
+			problems:  []VerificationError{{Type: "output_path", Name: "site", Severity: severityWarning}},
+			wantTests: 2, wantSkipped: 1,
+			wantClasses: []string{"verify.output_path", "verify"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ManifestHandler{Command: "verify", ManifestPath: "manifest.yml"}
+			out, err := junitReport(h.newReport(tt.problems))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var suites junitTestSuites
+			if err := xml.Unmarshal([]byte(out), &suites); err != nil {
+				t.Fatalf("not XML: %v\n%s", err, out)
+			}
+			if len(suites.Suites) != 1 {
+				t.Fatalf("got %d suites, want 1", len(suites.Suites))
+			}
+			s := suites.Suites[0]
+			if s.Tests != tt.wantTests || s.Failures != tt.wantFailures || s.Skipped != tt.wantSkipped || len(s.Cases) != tt.wantTests {
+				t.Errorf("suite tests=%d failures=%d skipped=%d cases=%d, want %d/%d/%d", s.Tests, s.Failures, s.Skipped, len(s.Cases), tt.wantTests, tt.wantFailures, tt.wantSkipped)
+			}
+			var failures, skipped int
+			for i, c := range s.Cases {
+				if i < len(tt.wantClasses) && c.ClassName != tt.wantClasses[i] {
+					t.Errorf("case %d classname = %q, want %q", i, c.ClassName, tt.wantClasses[i])
+				}
+				if c.Failure != nil {
+					failures++
+				}
+				if c.Skipped != nil {
+					skipped++
+				}
+			}
+			if failures != tt.wantFailures || skipped != tt.wantSkipped {
+				t.Errorf("cases have %d failures and %d skipped, want %d and %d", failures, skipped, tt.wantFailures, tt.wantSkipped)
+			}
+		})
+	}
+}
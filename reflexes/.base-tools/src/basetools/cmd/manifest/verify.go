@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"nhi/basetools/pkg/buildinfo"
+	"nhi/basetools/pkg/examples"
+	"nhi/basetools/pkg/manifesttypes"
+	"nhi/basetools/pkg/requirements"
+)
+
+// Base path of the input_* and output_* mounts, as in the entrypoint helper
+const appIOBasePath = "/app"
+
+// ST_RDONLY in the flags of statfs(2)
+const stReadOnly = 0x1
+
+// verifyState checks that the current environment, mounts and image satisfy
+// the manifest. It only looks: nothing is created or changed unless --fix
+// was given, in which case missing output directories are created and
+// handed to CALLING_UID/CALLING_GID before they are checked. It fails when
+// any problem is an error; warnings are only reported.
+func (h *ManifestHandler) verifyState(m manifesttypes.Manifest) error {
+	var errors []VerificationError
+
+	// Verify environment variables
+	errors = append(errors, h.verifyEnvironment(m.Environment)...)
+
+	// Verify input paths
+	errors = append(errors, h.verifyInputPaths(m.InputPaths)...)
+
+	// Verify output paths and permissions
+	errors = append(errors, h.verifyOutputs(m.OutputPaths)...)
+
+	// Verify the toolchain and the required executables and files
+	errors = append(errors, h.verifyBasetools(m.RequiresBasetools)...)
+	errors = append(errors, h.verifyRequirements(m.Requires)...)
+
+	r := h.newReport(errors)
+	if err := h.writeReport(r, verifyText); err != nil {
+		return err
+	}
+	if !r.Passed {
+		return fmt.Errorf("%d manifest requirements not satisfied", r.Errors)
+	}
+	return nil
+}
+
+func (h *ManifestHandler) verifyEnvironment(envVars map[string]manifesttypes.InputSpec) []VerificationError {
+	var errors []VerificationError
+
+	for _, name := range manifesttypes.SortedKeys(envVars) {
+		spec := envVars[name]
+		value := os.Getenv(name)
+
+		if spec.Required && value == "" {
+			errors = append(errors, VerificationError{
+				Type:        "environment",
+				Name:        name,
+				Description: "Required environment variable is not set",
+				Code:        "missing_env",
+				Line:        h.line("environment", name),
+			})
+			continue
+		}
+
+		if value != "" && spec.Pattern != "" {
+			matched, err := filepath.Match(spec.Pattern, value)
+			if err != nil {
+				errors = append(errors, VerificationError{
+					Type:        "environment",
+					Name:        name,
+					Description: fmt.Sprintf("Invalid pattern in manifest: %v", err),
+					Code:        "invalid_pattern",
+					Line:        h.line("environment", name),
+				})
+			} else if !matched {
+				errors = append(errors, VerificationError{
+					Type:        "environment",
+					Name:        name,
+					Description: fmt.Sprintf("Value does not match required pattern: %s", spec.Pattern),
+					Code:        "pattern_mismatch",
+					Line:        h.line("environment", name),
+				})
+			}
+		}
+	}
+
+	return errors
+}
+
+// mountPath returns where the helper expects the input or output called
+// name: the name itself when it is an absolute path or glob, otherwise
+// <io base>/<prefix>_<name>.
+func mountPath(prefix, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	base := os.Getenv(examples.IOBaseEnv)
+	if base == "" {
+		base = appIOBasePath
+	}
+	return filepath.Join(base, prefix+"_"+name)
+}
+
+func (h *ManifestHandler) verifyInputPaths(paths map[string]manifesttypes.PathSpec) []VerificationError {
+	var errors []VerificationError
+
+	for _, name := range manifesttypes.SortedKeys(paths) {
+		path := mountPath("input", name)
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			errors = append(errors, VerificationError{
+				Type:        "input_path",
+				Name:        name,
+				Description: fmt.Sprintf("Invalid path pattern: %v", err),
+				Code:        "invalid_pattern",
+				Line:        h.line("input_paths", name),
+			})
+			continue
+		}
+
+		switch {
+		case len(matches) > 0:
+		case paths[name].Required:
+			errors = append(errors, VerificationError{
+				Type:        "input_path",
+				Name:        name,
+				Description: fmt.Sprintf("Required input path not found: %s", path),
+				Code:        "missing_input",
+				Line:        h.line("input_paths", name),
+			})
+		default:
+			errors = append(errors, VerificationError{
+				Type:        "input_path",
+				Name:        name,
+				Description: fmt.Sprintf("Optional input path not found: %s", path),
+				Severity:    severityWarning,
+				Code:        "missing_optional_input",
+				Line:        h.line("input_paths", name),
+			})
+		}
+	}
+
+	return errors
+}
+
+// outputOwner is the CALLING_UID/CALLING_GID that outputs are handed to.
+type outputOwner struct {
+	UID int
+	GID int
+}
+
+// resolveOutputOwner reads CALLING_UID/CALLING_GID. It returns nil when
+// neither is set.
+func resolveOutputOwner() (*outputOwner, error) {
+	uidStr := os.Getenv(manifesttypes.CallingUIDEnv)
+	gidStr := os.Getenv(manifesttypes.CallingGIDEnv)
+	if uidStr == "" && gidStr == "" {
+		return nil, nil
+	}
+	uid, uidErr := strconv.Atoi(uidStr)
+	gid, gidErr := strconv.Atoi(gidStr)
+	if uidErr != nil || gidErr != nil || uid < 0 || gid < 0 {
+		return nil, fmt.Errorf("%s and %s must both be set to non-negative integers", manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv)
+	}
+	return &outputOwner{UID: uid, GID: gid}, nil
+}
+
+// outputDir returns the directory that must be writable for the output
+// called name, and the file within it when the output is a single file.
+func outputDir(name string, spec manifesttypes.PathSpec) (dir, file string) {
+	path := mountPath("output", name)
+	if spec.Type == "file" {
+		return filepath.Dir(path), path
+	}
+	return path, ""
+}
+
+// verifyOutputs checks every output location without changing anything: it
+// exists, has the declared type, is not on a read-only mount, and is
+// writable by this process and by CALLING_UID/CALLING_GID. Missing optional
+// outputs are only warned about, since the helper creates them.
+func (h *ManifestHandler) verifyOutputs(outputs map[string]manifesttypes.PathSpec) []VerificationError {
+	var errors []VerificationError
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	// Output paths need CALLING_UID/GID to be handed back to the caller
+	owner, err := resolveOutputOwner()
+	switch {
+	case err != nil:
+		errors = append(errors, VerificationError{
+			Type:        "permission",
+			Name:        "CALLING_UID/GID",
+			Description: err.Error(),
+			Code:        "invalid_calling_id",
+			Line:        h.line("output_paths"),
+		})
+	case owner == nil:
+		errors = append(errors, VerificationError{
+			Type:        "permission",
+			Name:        "CALLING_UID/GID",
+			Description: fmt.Sprintf("%s and %s must be set when output paths are specified", manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv),
+			Code:        "missing_calling_id",
+			Line:        h.line("output_paths"),
+		})
+	}
+
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		spec := outputs[name]
+		dir, file := outputDir(name, spec)
+		line := h.line("output_paths", name)
+		if h.Fix {
+			if err := h.fixOutput(dir, owner); err != nil {
+				errors = append(errors, VerificationError{Type: "output_path", Name: name, Description: err.Error(), Code: "fix_failed", Line: line})
+				continue
+			}
+		}
+		if problem := checkOutput(name, dir, file, spec, owner); problem != nil {
+			problem.Line = line
+			errors = append(errors, *problem)
+		}
+	}
+
+	return errors
+}
+
+// checkOutput inspects one output location and returns its first problem,
+// if any. Writability is judged from access(2) and the permission bits,
+// never by writing.
+func checkOutput(name, dir, file string, spec manifesttypes.PathSpec, owner *outputOwner) *VerificationError {
+	fail := func(typ, code, format string, args ...interface{}) *VerificationError {
+		return &VerificationError{Type: typ, Name: name, Description: fmt.Sprintf(format, args...), Code: code}
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err) && !spec.Required:
+		problem := fail("output_path", "missing_optional_output", "Optional output directory %s does not exist; the helper will create it and discard it with the container", dir)
+		problem.Severity = severityWarning
+		return problem
+	case os.IsNotExist(err):
+		return fail("output_path", "missing_output", "Output directory %s does not exist; mount it, or create it with --fix", dir)
+	case err != nil:
+		return fail("output_path", "unreadable_output", "Cannot check output directory %s: %v", dir, err)
+	case !info.IsDir():
+		return fail("output_path", "output_not_directory", "Output path %s is not a directory", dir)
+	}
+	if file != "" {
+		if fi, err := os.Lstat(file); err == nil && !fi.Mode().IsRegular() {
+			return fail("output_path", "output_not_file", "Output file %s exists but is not a regular file", file)
+		}
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err == nil && st.Flags&stReadOnly != 0 {
+		return fail("output_path", "read_only_output", "Output directory %s is mounted read-only; mount it without :ro", dir)
+	}
+	if err := syscall.Access(dir, 0x2 /* W_OK */); err != nil {
+		return fail("permission", "unwritable_output", "Output directory %s is not writable by the current user (UID: %d, GID: %d): %v", dir, os.Geteuid(), os.Getegid(), err)
+	}
+	if owner != nil && !writableByOwner(info, owner) {
+		uid, gid := fileOwner(info)
+		return fail("permission", "output_not_owned", "Output directory %s (owner %d:%d, mode %s) is not writable by %d:%d; hand it over with --fix",
+			dir, uid, gid, info.Mode().Perm(), owner.UID, owner.GID)
+	}
+	return nil
+}
+
+// fixOutput creates a missing output directory and hands it to the calling
+// user. Only the directory itself is re-owned; anything already in it
+// belongs to the host and is left alone.
+func (h *ManifestHandler) fixOutput(dir string, owner *outputOwner) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Cannot create output directory %s: %v", dir, err)
+		}
+		h.fixes = append(h.fixes, fmt.Sprintf("created %s", dir))
+		info, err = os.Stat(dir)
+	}
+	if err != nil || !info.IsDir() || owner == nil {
+		return nil // Reported by checkOutput
+	}
+	if uid, gid := fileOwner(info); uid == owner.UID && gid == owner.GID {
+		return nil
+	}
+	if err := os.Chown(dir, owner.UID, owner.GID); err != nil {
+		return fmt.Errorf("Cannot hand output directory %s to %d:%d: %v", dir, owner.UID, owner.GID, err)
+	}
+	h.fixes = append(h.fixes, fmt.Sprintf("changed the owner of %s to %d:%d", dir, owner.UID, owner.GID))
+	return nil
+}
+
+// fileOwner returns the UID and GID that own info, or -1 when unknown.
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(st.Uid), int(st.Gid)
+}
+
+// writableByOwner reports whether the permission bits of the directory info
+// grant write and search access to owner. Supplementary groups are unknown
+// here, so only the primary group counts.
+func writableByOwner(info os.FileInfo, owner *outputOwner) bool {
+	uid, gid := fileOwner(info)
+	perm := info.Mode().Perm()
+	switch {
+	case owner.UID == 0:
+		return true
+	case uid == owner.UID:
+		return perm&0o300 == 0o300
+	case gid == owner.GID:
+		return perm&0o030 == 0o030
+	}
+	return perm&0o003 == 0o003
+}
+
+func (h *ManifestHandler) verifyBasetools(constraint string) []VerificationError {
+	if constraint == "" {
+		return nil
+	}
+	ok, err := buildinfo.Satisfies(constraint)
+	switch {
+	case err != nil:
+		return []VerificationError{{
+			Type:        "requirement",
+			Name:        "requires_basetools",
+			Description: err.Error(),
+			Code:        "invalid_constraint",
+			Line:        h.line("requires_basetools"),
+		}}
+	case !ok:
+		return []VerificationError{{
+			Type:        "requirement",
+			Name:        "requires_basetools",
+			Description: fmt.Sprintf("Requires basetools %s, but this is basetools %s", constraint, buildinfo.Version),
+			Code:        "incompatible_basetools",
+			Line:        h.line("requires_basetools"),
+		}}
+	}
+	return nil
+}
+
+func (h *ManifestHandler) verifyRequirements(spec *manifesttypes.RequiresSpec) []VerificationError {
+	if spec == nil {
+		return nil
+	}
+	var errors []VerificationError
+
+	// Each entry is checked on its own so a problem points at its own line
+	check := func(entry *manifesttypes.RequiresSpec, line int) {
+		for _, problem := range requirements.Check(entry) {
+			errors = append(errors, VerificationError{
+				Type:        "requirement",
+				Name:        problem.Requirement,
+				Description: problem.Reason,
+				Code:        "missing_requirement",
+				Line:        line,
+			})
+		}
+	}
+	for i, exe := range spec.Executables {
+		check(&manifesttypes.RequiresSpec{Executables: []manifesttypes.ExecutableRequirement{exe}}, h.line("requires", "executables", strconv.Itoa(i)))
+	}
+	for i, file := range spec.Files {
+		check(&manifesttypes.RequiresSpec{Files: []string{file}}, h.line("requires", "files", strconv.Itoa(i)))
+	}
+
+	return errors
+}
+
+// verifyText renders a verify report as text: the fixes made, then the
+// problems grouped by type, warnings last.
+func verifyText(r verificationReport) string {
+	var output strings.Builder
+	for _, fix := range r.Fixes {
+		output.WriteString(fmt.Sprintf("Fixed: %s\n", fix))
+	}
+	if len(r.Fixes) > 0 {
+		output.WriteString("\n")
+	}
+
+	// Group errors by type
+	grouped := make(map[string][]VerificationError)
+	var warnings []VerificationError
+	for _, p := range r.Problems {
+		if p.Severity == severityWarning {
+			warnings = append(warnings, p)
+			continue
+		}
+		grouped[p.Type] = append(grouped[p.Type], p)
+	}
+
+	if r.Passed {
+		output.WriteString("✓ All manifest requirements satisfied\n")
+	} else {
+		output.WriteString("❌ Manifest requirements not satisfied:\n\n")
+	}
+
+	for _, errType := range []string{"environment", "input_path", "output_path", "permission", "requirement"} {
+		if errs, ok := grouped[errType]; ok {
+			switch errType {
+			case "environment":
+				output.WriteString("Environment Variables:\n")
+			case "input_path":
+				output.WriteString("Input Paths:\n")
+			case "output_path":
+				output.WriteString("Output Paths:\n")
+			case "permission":
+				output.WriteString("Permissions:\n")
+			case "requirement":
+				output.WriteString("Requirements:\n")
+			}
+
+			for _, err := range errs {
+				output.WriteString(problemLine(err))
+			}
+			output.WriteString("\n")
+		}
+	}
+
+	if len(warnings) > 0 {
+		if r.Passed {
+			output.WriteString("\n")
+		}
+		output.WriteString("Warnings:\n")
+		for _, w := range warnings {
+			output.WriteString(problemLine(w))
+		}
+	}
+	return output.String()
+}
+
+// problemLine renders one problem as a list item, with its manifest line and
+// code.
+func problemLine(p VerificationError) string {
+	at := ""
+	if p.Line > 0 {
+		at = fmt.Sprintf(" (line %d)", p.Line)
+	}
+	return fmt.Sprintf("  - %s%s: %s [%s]\n", p.Name, at, p.Description, p.Code)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"nhi/basetools/pkg/examples"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// snapshot lists every path under root with its mode and owner, to detect
+// any change to the filesystem.
+func snapshot(t *testing.T, root string) string {
+	t.Helper()
+	var lines []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		uid, gid := fileOwner(info)
+		lines = append(lines, fmt.Sprintf("%s %s %d:%d", path, info.Mode(), uid, gid))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestVerifyOutputs(t *testing.T) {
+	self := strconv.Itoa(os.Getuid())
+	selfGroup := strconv.Itoa(os.Getgid())
+	dir := manifesttypes.PathSpec{Type: "directory", Required: true}
+	tests := []struct {
+		name      string
+		outputs   map[string]manifesttypes.PathSpec
+		setup     func(t *testing.T, base string)
+		owner     string // CALLING_UID and CALLING_GID; the current user when empty
+		fix       bool
+		needsRoot bool
+		wantCodes []string
+		wantFixes int
+		wantDirs  []string // Output directories that must exist afterwards
+	}{
+		{name: "missing output", outputs: map[string]manifesttypes.PathSpec{"site": dir}, wantCodes: []string{"missing_output"}},
+		{
+			name:      "missing output fixed",
+			outputs:   map[string]manifesttypes.PathSpec{"site": dir},
+			fix:       true,
+			wantFixes: 1,
+			wantDirs:  []string{"output_site"},
+		},
+		{
+			name:      "missing optional output",
+			outputs:   map[string]manifesttypes.PathSpec{"extra": {Type: "directory"}},
+			wantCodes: []string{"missing_optional_output"},
+		},
+		{
+			name:      "output is a file",
+			outputs:   map[string]manifesttypes.PathSpec{"site": dir},
+			setup:     func(t *testing.T, base string) { writeFile(t, filepath.Join(base, "output_site"), "") },
+			fix:       true,
+			wantCodes: []string{"output_not_directory"},
+		},
+		{
+			name:    "file output is a directory",
+			outputs: map[string]manifesttypes.PathSpec{"report.md": {Type: "file", Required: true}},
+			setup: func(t *testing.T, base string) {
+				if err := os.Mkdir(filepath.Join(base, "output_report.md"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCodes: []string{"output_not_file"},
+		},
+		{
+			name:      "owned by someone else",
+			outputs:   map[string]manifesttypes.PathSpec{"site": dir},
+			setup:     func(t *testing.T, base string) { mkdirMode(t, filepath.Join(base, "output_site"), 0o755) },
+			owner:     "65534",
+			wantCodes: []string{"output_not_owned"},
+		},
+		{
+			name:      "handed over with --fix",
+			outputs:   map[string]manifesttypes.PathSpec{"site": dir},
+			setup:     func(t *testing.T, base string) { mkdirMode(t, filepath.Join(base, "output_site"), 0o755) },
+			owner:     "65534",
+			fix:       true,
+			needsRoot: true,
+			wantFixes: 1,
+			wantDirs:  []string{"output_site"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsRoot && os.Geteuid() != 0 {
+				t.Skip("changing the owner of a directory needs root")
+			}
+			base := t.TempDir()
+			t.Setenv(examples.IOBaseEnv, base)
+			uid, gid := self, selfGroup
+			if tt.owner != "" {
+				uid, gid = tt.owner, tt.owner
+			}
+			t.Setenv(manifesttypes.CallingUIDEnv, uid)
+			t.Setenv(manifesttypes.CallingGIDEnv, gid)
+			if tt.setup != nil {
+				tt.setup(t, base)
+			}
+			before := snapshot(t, base)
+
+			h := &ManifestHandler{Command: "verify", Fix: tt.fix}
+			problems := h.verifyOutputs(tt.outputs)
+			var codes []string
+			for _, p := range problems {
+				codes = append(codes, p.Code)
+			}
+			if strings.Join(codes, ",") != strings.Join(tt.wantCodes, ",") {
+				t.Errorf("problems %v, want codes %v", problems, tt.wantCodes)
+			}
+			if len(h.fixes) != tt.wantFixes {
+				t.Errorf("fixes %q, want %d", h.fixes, tt.wantFixes)
+			}
+			if !tt.fix {
+				if after := snapshot(t, base); after != before {
+					t.Errorf("verify without --fix changed the filesystem:\nbefore:\n%s\nafter:\n%s", before, after)
+				}
+			}
+			for _, d := range tt.wantDirs {
+				info, err := os.Stat(filepath.Join(base, d))
+				if err != nil || !info.IsDir() {
+					t.Errorf("%s is not a directory: %v", d, err)
+					continue
+				}
+				if got, _ := fileOwner(info); strconv.Itoa(got) != uid {
+					t.Errorf("%s is owned by %d, want %s", d, got, uid)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mkdirMode(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	if err := os.Mkdir(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
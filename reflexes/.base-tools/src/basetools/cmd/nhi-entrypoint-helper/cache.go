@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Layout of the result cache below NHI_CACHE_DIR: one directory per key
+// holding the stdout of the run, its scalar outputs and a copy of each
+// output, plus a stats file.
+// An entry's modification time records when it was last used.
+const (
+	cacheStatsFile  = "stats.json"
+	cacheStdoutFile = "stdout"
+	cacheValuesFile = "values.json"
+	cacheOutputsDir = "outputs"
+	cacheTmpPrefix  = ".tmp-"
+	cacheImageIDEnv = "NHI_IMAGE_ID"
+	cacheKeyVersion = "nhi-cache-v1"
+)
+
+// resultCache stores the stdout and outputs of successful runs, keyed by
+// everything that determines them. It relies on reflexes being deterministic.
+type resultCache struct {
+	Dir      string
+	MaxBytes int64 // Evict least recently used entries above this size; 0 means unlimited
+}
+
+// cacheStats are the counters kept in stats.json.
+type cacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Stores    int64 `json:"stores"`
+	Evictions int64 `json:"evictions"`
+}
+
+// cacheKey digests everything a deterministic run depends on: the manifest,
+// the image, the command and subcommand, the declared environment values, the content of
+// every declared input and, when the manifest declares stdin, the digest of
+// the buffered stdin. It fails when the run cannot be cached safely: when
+// the image id is unknown, since the reflex code is then not part of the key;
+// when declared stdin is streamed rather than buffered; when stdin may carry
+// input the manifest declares no contract for, since the reflex would read
+// it without it being part of the key; and when outputs are written straight
+// into mounts that may hold content from earlier runs, since that content
+// would be stored with the result. Tar runs start from empty outputs.
+func cacheKey(manifestData []byte, p *invocationPlan, stdin *stdinSource) (string, error) {
+	imageID := os.Getenv(cacheImageIDEnv)
+	if imageID == "" {
+		return "", fmt.Errorf("%s is not set; pass the image id to enable caching", cacheImageIDEnv)
+	}
+	if p.manifest.Stdin == nil && stdin.Opaque {
+		return "", fmt.Errorf("stdin is piped but the manifest declares no stdin contract; declare stdin so it is part of the cache key")
+	}
+	if !p.settings.StageOutputs && p.settings.IOMode != ioModeTar {
+		return "", fmt.Errorf("outputs are not staged, so what the run wrote cannot be told from earlier content; enable runtime.stage_outputs")
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", cacheKeyVersion)
+	fmt.Fprintf(h, "manifest %x\n", sha256.Sum256(manifestData))
+	fmt.Fprintf(h, "image %s\n", imageID)
+	cmd, _ := json.Marshal(p.Command)
+	fmt.Fprintf(h, "command %s\n", cmd)
+	if p.Subcommand != "" {
+		fmt.Fprintf(h, "subcommand %s\n", p.Subcommand)
+	}
+
+	// Declared variables only, as resolved by the plan (defaults included)
+	declared := make(map[string]string)
+	for _, e := range p.Environment {
+		if _, ok := p.manifest.Environment[e.Name]; ok {
+			declared[e.Name] = e.Value
+		}
+	}
+	for _, name := range manifesttypes.SortedKeys(p.manifest.Environment) {
+		if v, ok := declared[name]; ok {
+			fmt.Fprintf(h, "env %s=%q\n", name, v)
+		} else {
+			fmt.Fprintf(h, "env %s unset\n", name)
+		}
+	}
+
+	if p.manifest.Stdin != nil {
+		if stdin.Digest == "" {
+			return "", fmt.Errorf("stdin is streamed, so it cannot be part of the cache key")
+		}
+		fmt.Fprintf(h, "stdin %s\n", stdin.Digest)
+	}
+
+	for _, mp := range p.Inputs {
+		if !mp.Found {
+			fmt.Fprintf(h, "input %s absent\n", mp.Name)
+			continue
+		}
+		digest, err := treeDigest(mp.Path)
+		if err != nil {
+			return "", fmt.Errorf("digesting input '%s': %w", mp.Name, err)
+		}
+		fmt.Fprintf(h, "input %s %s\n", mp.Name, digest)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// treeDigest hashes a file or directory tree: the relative path, type and
+// content of every entry in lexical order. Permission bits and timestamps
+// are ignored so copies of the same content hash alike.
+func treeDigest(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			fmt.Fprintf(h, "dir %q\n", rel)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "symlink %q %q\n", rel, link)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			fh := sha256.New()
+			_, err = io.Copy(fh, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "file %q %x\n", rel, fh.Sum(nil))
+		default:
+			fmt.Fprintf(h, "other %q\n", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entry returns the directory of the cache entry for key.
+func (c *resultCache) entry(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+// lookup reports whether a complete entry exists for key.
+func (c *resultCache) lookup(key string) bool {
+	_, err := os.Stat(filepath.Join(c.entry(key), cacheStdoutFile))
+	return err == nil
+}
+
+// restore replays a cache hit: the cached stdout is written to stdout and
+// each output's cached entries replace those in dirs, which maps an output
+// name to the directory to restore into (its mount or staging directory).
+// It returns the cached scalar outputs.
+func (c *resultCache) restore(key string, dirs map[string]string, stdout io.Writer) (map[string]interface{}, error) {
+	entry := c.entry(key)
+	now := time.Now()
+	_ = os.Chtimes(entry, now, now) // Mark as recently used
+
+	for _, name := range manifesttypes.SortedKeys(dirs) {
+		src := filepath.Join(entry, cacheOutputsDir, name)
+		entries, err := os.ReadDir(src)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("restoring output '%s' from cache: %w", name, err)
+		}
+		for _, e := range entries {
+			dst := filepath.Join(dirs[name], e.Name())
+			if err := os.RemoveAll(dst); err != nil {
+				return nil, fmt.Errorf("restoring output '%s' from cache: %w", name, err)
+			}
+			if err := copyTree(filepath.Join(src, e.Name()), dst, nil); err != nil {
+				return nil, fmt.Errorf("restoring output '%s' from cache: %w", name, err)
+			}
+		}
+	}
+
+	values := map[string]interface{}{}
+	data, err := os.ReadFile(filepath.Join(entry, cacheValuesFile))
+	if err == nil {
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("restoring scalar outputs from cache: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(entry, cacheStdoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("restoring stdout from cache: %w", err)
+	}
+	defer f.Close()
+	_, err = io.Copy(stdout, f)
+	return values, err
+}
+
+// begin prepares a temporary entry for runID and returns it with the file
+// the run's stdout should be copied to.
+func (c *resultCache) begin(runID string) (string, *os.File, error) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return "", nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+	tmp := filepath.Join(c.Dir, cacheTmpPrefix+runID)
+	if err := os.Mkdir(tmp, 0o700); err != nil {
+		return "", nil, fmt.Errorf("creating cache entry: %w", err)
+	}
+	f, err := os.Create(filepath.Join(tmp, cacheStdoutFile))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, fmt.Errorf("creating cache entry: %w", err)
+	}
+	return tmp, f, nil
+}
+
+// commit copies the published outputs and the scalar outputs into the
+// temporary entry and renames it into place under key. If another run stored
+// the same key first, that entry is kept.
+func (c *resultCache) commit(tmp, key string, outputs map[string]string, values map[string]interface{}) error {
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		dst := filepath.Join(tmp, cacheOutputsDir, name)
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			return fmt.Errorf("caching output '%s': %w", name, err)
+		}
+		entries, err := os.ReadDir(outputs[name])
+		if err != nil {
+			return fmt.Errorf("caching output '%s': %w", name, err)
+		}
+		for _, e := range entries {
+			if !isReflexEntry(e.Name()) {
+				continue
+			}
+			if err := copyTree(filepath.Join(outputs[name], e.Name()), filepath.Join(dst, e.Name()), nil); err != nil {
+				return fmt.Errorf("caching output '%s': %w", name, err)
+			}
+		}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("caching scalar outputs: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, cacheValuesFile), data, 0o644); err != nil {
+		return fmt.Errorf("caching scalar outputs: %w", err)
+	}
+	if err := os.Rename(tmp, c.entry(key)); err != nil {
+		if c.lookup(key) {
+			return os.RemoveAll(tmp)
+		}
+		return fmt.Errorf("storing cache entry: %w", err)
+	}
+	return nil
+}
+
+// cacheEntryInfo describes one stored entry for eviction and stats.
+type cacheEntryInfo struct {
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// entries lists the stored entries, least recently used first.
+func (c *resultCache) entries() ([]cacheEntryInfo, error) {
+	dirents, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []cacheEntryInfo
+	for _, d := range dirents {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), cacheTmpPrefix) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.Dir, d.Name())
+		list = append(list, cacheEntryInfo{Path: path, Size: dirSize(path), LastUsed: info.ModTime()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.Before(list[j].LastUsed) })
+	return list, nil
+}
+
+// evict removes least recently used entries until the cache fits MaxBytes.
+// It returns the number of entries removed.
+func (c *resultCache) evict(logger *slog.Logger) (int64, error) {
+	if c.MaxBytes <= 0 {
+		return 0, nil
+	}
+	list, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range list {
+		total += e.Size
+	}
+	var evicted int64
+	for _, e := range list {
+		if total <= c.MaxBytes {
+			break
+		}
+		logger.Info("Evicting cache entry", "path", e.Path, "size", e.Size)
+		if err := os.RemoveAll(e.Path); err != nil {
+			return evicted, err
+		}
+		total -= e.Size
+		evicted++
+	}
+	return evicted, nil
+}
+
+// updateStats adds delta to the counters in stats.json. Concurrent runs may
+// lose an update; the counters are indicative only.
+func (c *resultCache) updateStats(delta cacheStats) {
+	path := filepath.Join(c.Dir, cacheStatsFile)
+	stats := c.readStats()
+	stats.Hits += delta.Hits
+	stats.Misses += delta.Misses
+	stats.Stores += delta.Stores
+	stats.Evictions += delta.Evictions
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return
+	}
+	tmp := path + cacheTmpPrefix + fmt.Sprint(os.Getpid())
+	if err := os.WriteFile(tmp, data, 0o644); err == nil {
+		_ = os.Rename(tmp, path)
+	}
+}
+
+func (c *resultCache) readStats() cacheStats {
+	var stats cacheStats
+	if data, err := os.ReadFile(filepath.Join(c.Dir, cacheStatsFile)); err == nil {
+		_ = json.Unmarshal(data, &stats)
+	}
+	return stats
+}
+
+// writeStats prints the counters and the current size of the cache.
+func (c *resultCache) writeStats(w io.Writer) error {
+	list, err := c.entries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range list {
+		total += e.Size
+	}
+	stats := c.readStats()
+	fmt.Fprintf(w, "Cache directory: %s\n", c.Dir)
+	fmt.Fprintf(w, "Entries:         %d\n", len(list))
+	fmt.Fprintf(w, "Size:            %d bytes\n", total)
+	if c.MaxBytes > 0 {
+		fmt.Fprintf(w, "Max size:        %d bytes\n", c.MaxBytes)
+	}
+	fmt.Fprintf(w, "Hits:            %d\n", stats.Hits)
+	fmt.Fprintf(w, "Misses:          %d\n", stats.Misses)
+	fmt.Fprintf(w, "Stores:          %d\n", stats.Stores)
+	fmt.Fprintf(w, "Evictions:       %d\n", stats.Evictions)
+	return nil
+}
+
+// replay restores the cached result for key in place of running the reflex.
+// With staged outputs, the cached content is published like a real run's.
+// The cached stdout is copied to stdout, and the cached scalar outputs are
+// returned.
+func (c *resultCache) replay(logger *slog.Logger, key string, outputs map[string]string, settings runSettings, runID string, id *identity, stdout io.Writer) (map[string]interface{}, error) {
+	dirs := outputs
+	var stages []outputStage
+	if settings.StageOutputs {
+		var err error
+		if stages, err = createOutputStages(outputs, runID, id); err != nil {
+			_ = discardOutputs(logger, stages, runID, false)
+			return nil, err
+		}
+		dirs = make(map[string]string)
+		for _, st := range stages {
+			dirs[st.Name] = st.Staging
+		}
+	}
+	values, err := c.restore(key, dirs, stdout)
+	if err != nil {
+		_ = discardOutputs(logger, stages, runID, false)
+		return nil, err
+	}
+	if err := publishOutputs(logger, stages, runID); err != nil {
+		_ = discardOutputs(logger, stages, runID, false)
+		return nil, err
+	}
+	return values, nil
+}
+
+// store finishes the temporary entry begun for this run. The result is kept
+// only when ok, that is when the run and its publishing succeeded. Cache
+// failures never fail the run; they are logged.
+func (c *resultCache) store(logger *slog.Logger, tmp string, stdout *os.File, key string, outputs map[string]string, values map[string]interface{}, ok bool) {
+	if err := stdout.Close(); err != nil || !ok {
+		os.RemoveAll(tmp)
+		return
+	}
+	if err := c.commit(tmp, key, outputs, values); err != nil {
+		logger.Warn("Could not store result in cache", "error", err)
+		os.RemoveAll(tmp)
+		return
+	}
+	logger.Info("Stored result in cache", "key", key)
+	evicted, err := c.evict(logger)
+	if err != nil {
+		logger.Warn("Could not evict cache entries", "error", err)
+	}
+	c.updateStats(cacheStats{Stores: 1, Evictions: evicted})
+}
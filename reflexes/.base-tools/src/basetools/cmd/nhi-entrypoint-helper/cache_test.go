@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestCacheKeyRefusesUnsafeRuns(t *testing.T) {
+	t.Setenv(cacheImageIDEnv, "sha256:test")
+	declared := &manifesttypes.PathSpec{Type: "json"}
+	tests := []struct {
+		name     string
+		stdin    *manifesttypes.PathSpec // The manifest's stdin contract
+		source   stdinSource
+		settings runSettings
+		wantErr  string
+	}{
+		{name: "staged outputs", settings: runSettings{StageOutputs: true}},
+		{name: "tar runs start from empty outputs", settings: runSettings{IOMode: ioModeTar}},
+		{name: "unstaged mounts", settings: runSettings{}, wantErr: "not staged"},
+		{name: "piped stdin without a contract", source: stdinSource{Opaque: true}, settings: runSettings{StageOutputs: true}, wantErr: "no stdin contract"},
+		{name: "buffered stdin", stdin: declared, source: stdinSource{Digest: "abc"}, settings: runSettings{StageOutputs: true}},
+		{name: "streamed stdin", stdin: declared, settings: runSettings{StageOutputs: true}, wantErr: "streamed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &invocationPlan{manifest: manifesttypes.Manifest{Stdin: tt.stdin}, Command: []string{"reflex"}, settings: tt.settings}
+			key, err := cacheKey([]byte("name: test\n"), p, &tt.source)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr == "" && key == "":
+				t.Fatal("got an empty key")
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMayHoldInput(t *testing.T) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	tests := []struct {
+		name string
+		r    io.Reader
+		want bool
+	}{
+		{name: "/dev/null", r: devNull},
+		{name: "pipe", r: pr, want: true},
+		{name: "empty tar stdin", r: strings.NewReader("")},
+		{name: "tar stdin member", r: strings.NewReader("data"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mayHoldInput(tt.r); got != tt.want {
+				t.Fatalf("mayHoldInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
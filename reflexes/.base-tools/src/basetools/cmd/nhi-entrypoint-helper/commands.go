@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// subcommandName returns the subcommand of a multi-command reflex that args
+// select, along with the arguments left for it: the first argument when it
+// names a subcommand, otherwise NHI_COMMAND with all of args. The name is
+// empty when neither selects one, and may be unknown when it comes from
+// NHI_COMMAND.
+func subcommandName(m manifesttypes.Manifest, args []string) (string, []string) {
+	if len(args) > 0 {
+		if _, ok := m.Commands[args[0]]; ok {
+			return args[0], args[1:]
+		}
+	}
+	return os.Getenv(manifesttypes.CommandEnv), args
+}
+
+// selectSubcommand resolves the subcommand args select. It returns its name,
+// the manifest as it applies to it and its command line, followed by the
+// remaining arguments.
+func selectSubcommand(m manifesttypes.Manifest, args []string) (string, manifesttypes.Manifest, []string, *helperError) {
+	names := manifesttypes.SortedKeys(m.Commands)
+	hint := fmt.Sprintf("Pass one of %s as the first argument, or set %s", strings.Join(names, ", "), manifesttypes.CommandEnv)
+	name, rest := subcommandName(m, args)
+	if name == "" {
+		return "", m, nil, newError(categoryUsage, "", hint, "No subcommand given")
+	}
+	resolved, err := m.ForCommand(name)
+	if err != nil {
+		return "", m, nil, newError(categoryUsage, manifesttypes.CommandEnv, hint, "Unknown subcommand %q", name)
+	}
+	if len(resolved.Command) == 0 {
+		return "", m, nil, newError(categoryUsage, name, "Set commands."+name+".command in manifest.yml",
+			"Subcommand %q has no command", name)
+	}
+	cmdArgs := append(append([]string(nil), resolved.Command...), rest...)
+	return name, resolved, cmdArgs, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+
+	"nhi/basetools/pkg/buildinfo"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// checkBasetools refuses a manifest whose requires_basetools constraint this
+// helper does not satisfy, rather than silently ignoring manifest fields an
+// older toolchain does not know about.
+func checkBasetools(logger *slog.Logger, m manifesttypes.Manifest) *helperError {
+	if m.RequiresBasetools == "" {
+		return nil
+	}
+	ok, err := buildinfo.Satisfies(m.RequiresBasetools)
+	if err != nil {
+		return newError(categoryUsage, "requires_basetools", "Use a constraint such as \">=1.2\" or \">=1.2, <2\"", "%v", err)
+	}
+	if buildinfo.IsDev() {
+		logger.Warn("Development build of basetools; assuming it satisfies requires_basetools", "requires_basetools", m.RequiresBasetools)
+	}
+	if !ok {
+		return newError(categoryMissingRequirement, "requires_basetools", "Rebuild the image against a newer .base-tools",
+			"This manifest requires basetools %s, but the image has basetools %s", m.RequiresBasetools, buildinfo.Version)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Exit codes reserved by the helper. They follow sysexits(3) where one fits;
+// any other exit code is the reflex's own. A reflex should avoid these codes
+// so callers can tell helper failures from reflex failures.
+const (
+	exitUsage              = 64  // EX_USAGE: bad flags, helper settings or invocation
+	exitContractViolation  = 65  // EX_DATAERR: a value or output breaks the manifest contract
+	exitMissingInput       = 66  // EX_NOINPUT: a required input mount is missing
+	exitMissingRequirement = 69  // EX_UNAVAILABLE: the image lacks a tool or file the reflex requires
+	exitInternal           = 70  // EX_SOFTWARE: the helper itself failed
+	exitUnwritableOutput   = 73  // EX_CANTCREAT: an output mount is missing or not writable
+	exitMissingEnv         = 78  // EX_CONFIG: a required environment variable is not set
+	exitCommandNotFound    = 127 // As shells do
+	// exitTimeout (124) is defined with the run supervisor
+)
+
+// Error categories, as reported by NHI_ERROR_FORMAT=json
+const (
+	categoryUsage              = "usage"
+	categoryMissingEnv         = "missing_env"
+	categoryMissingInput       = "missing_input"
+	categoryUnwritableOutput   = "unwritable_output"
+	categoryContractViolation  = "contract_violation"
+	categoryTimeout            = "timeout"
+	categoryInternal           = "internal"
+	categoryCommandNotFound    = "command_not_found"
+	categoryMissingRequirement = "missing_requirement"
+)
+
+// exitCodes maps each category to its reserved exit code.
+var exitCodes = map[string]int{
+	categoryUsage:              exitUsage,
+	categoryMissingEnv:         exitMissingEnv,
+	categoryMissingInput:       exitMissingInput,
+	categoryUnwritableOutput:   exitUnwritableOutput,
+	categoryContractViolation:  exitContractViolation,
+	categoryTimeout:            exitTimeout,
+	categoryInternal:           exitInternal,
+	categoryCommandNotFound:    exitCommandNotFound,
+	categoryMissingRequirement: exitMissingRequirement,
+}
+
+// errorFormat is the format helper errors are reported in, from
+// NHI_ERROR_FORMAT: text (default) or json.
+var errorFormat = "text"
+
+// helperError is a failure detected by the helper rather than by the reflex.
+type helperError struct {
+	Category string `json:"category"`
+	Code     int    `json:"exit_code"`
+	Input    string `json:"input,omitempty"` // Environment variable, input or output concerned
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"` // How to fix it
+}
+
+// newError builds a helperError; the exit code follows from the category.
+func newError(category, input, hint, format string, args ...interface{}) *helperError {
+	return &helperError{
+		Category: category,
+		Code:     exitCodes[category],
+		Input:    input,
+		Message:  fmt.Sprintf(format, args...),
+		Hint:     hint,
+	}
+}
+
+// internalError wraps an unexpected failure of the helper itself.
+func internalError(err error) *helperError {
+	return newError(categoryInternal, "", "", "%v", err)
+}
+
+func (e *helperError) Error() string {
+	return e.Message
+}
+
+// writeErrors reports errs to w as free text or, with format "json", as one
+// JSON object per line.
+func writeErrors(w io.Writer, errs []*helperError, format string) {
+	for _, e := range errs {
+		if strings.ToLower(format) == "json" {
+			data, err := json.Marshal(e)
+			if err == nil {
+				fmt.Fprintln(w, string(data))
+				continue
+			}
+		}
+		fmt.Fprintf(w, "Error: %s\n", e.Message)
+		if e.Hint != "" {
+			fmt.Fprintf(w, "  Hint: %s\n", e.Hint)
+		}
+	}
+}
+
+// exitCodeFor returns the exit code for a set of errors: that of the first.
+func exitCodeFor(errs []*helperError) int {
+	if len(errs) == 0 {
+		return 0
+	}
+	return errs[0].Code
+}
+
+// fail reports errs on stderr and exits with the code of the first.
+func fail(errs ...*helperError) {
+	writeErrors(os.Stderr, errs, errorFormat)
+	os.Exit(exitCodeFor(errs))
+}
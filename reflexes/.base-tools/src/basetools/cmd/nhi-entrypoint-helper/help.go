@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+const usageLine = "<docker run options> <image> [-h|--help [--format=text|markdown|json]] [--dry-run [--plan-format=text|json]] [--no-cache] [--cache-stats] [--version] <command> [args...]"
+
+// helpEnv documents one environment variable.
+type helpEnv struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+}
+
+// helpMount documents one input or output mount.
+type helpMount struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	EnvVar      string `json:"env_var"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Format      string `json:"format,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Staging     string `json:"staging,omitempty"`
+}
+
+// helpStdin documents what the reflex reads from stdin.
+type helpStdin struct {
+	Type        string      `json:"type,omitempty"`
+	Format      string      `json:"format,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required"`
+	MaxSize     string      `json:"max_size,omitempty"`
+	Schema      interface{} `json:"schema,omitempty"`
+}
+
+// helpStdout documents what the reflex writes to stdout.
+type helpStdout struct {
+	Type        string `json:"type,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// helpCommand documents one subcommand of a multi-command reflex.
+type helpCommand struct {
+	Name        string   `json:"name"`
+	Command     []string `json:"command"`
+	Description string   `json:"description,omitempty"`
+}
+
+// helpDoc is the reflex contract as rendered by --help. Every list is sorted
+// by name so the rendering is identical from run to run.
+type helpDoc struct {
+	Name              string        `json:"name,omitempty"`
+	Version           string        `json:"version,omitempty"`
+	Description       string        `json:"description,omitempty"`
+	Subcommand        string        `json:"subcommand,omitempty"` // Subcommand the contract below is for
+	Commands          []helpCommand `json:"commands,omitempty"`
+	Usage             string        `json:"usage"`
+	Environment       []helpEnv     `json:"environment"`
+	Inputs            []helpMount   `json:"inputs"`
+	Outputs           []helpMount   `json:"outputs"`
+	Stdin             *helpStdin    `json:"stdin,omitempty"`
+	Stdout            *helpStdout   `json:"stdout,omitempty"`
+	ScalarOutputs     []helpEnv     `json:"scalar_outputs"`
+	Examples          []string      `json:"examples"`
+	HelperEnvironment []helpEnv     `json:"helper_environment"`
+}
+
+// helperEnvironment lists the variables understood by the helper itself,
+// sorted by name.
+var helperEnvironment = []helpEnv{
+	{Name: "CALLING_UID, CALLING_GID", Description: "Hand the contents of output mounts to this UID/GID after the reflex exits."},
+	{Name: "NHI_CACHE_DIR", Description: "Cache results of successful runs here and replay them for identical runs (requires NHI_IMAGE_ID)."},
+	{Name: "NHI_CACHE_MAX_SIZE", Description: "Evict least recently used cache entries above this size, e.g. 2G."},
+	{Name: "NHI_COMMAND", Description: "Subcommand of a multi-command reflex to run when the first argument does not name one."},
+	{Name: "NHI_DRY_RUN", Description: "Set to 1 to validate and print the invocation plan without executing anything (same as --dry-run)."},
+	{Name: "NHI_ERROR_FORMAT", Description: "Report helper errors as text or as one JSON object per line on stderr (text|json)."},
+	{Name: "NHI_IMAGE_ID", Description: "Id of the reflex image, part of the cache key."},
+	{Name: "NHI_IO", Description: "I/O mode (mount|tar, default mount). With tar, inputs are read as a tar on stdin and outputs written as a tar on stdout."},
+	{Name: "NHI_IO_BASE", Description: "Directory holding the input_* and output_* mounts (default /app); used to run examples against fixture copies."},
+	{Name: "NHI_KEEP_FAILED_OUTPUTS", Description: "Keep staged outputs of failed runs under .failed-<run id> (overrides runtime.keep_failed_outputs)."},
+	{Name: "NHI_KEEP_TMPDIR_ON_FAILURE", Description: "Keep the run workspace when the run fails (overrides runtime.keep_tmpdir_on_failure)."},
+	{Name: "NHI_LOG_FILE", Description: "Append helper logs to this file instead of stderr."},
+	{Name: "NHI_LOG_FORMAT", Description: "Helper log format (text|json, default text)."},
+	{Name: "NHI_LOG_LEVEL", Description: "Helper log verbosity (debug|info|warn|error, default warn)."},
+	{Name: "NHI_NO_CACHE", Description: "Set to 1 to run the reflex even if a cached result exists (same as --no-cache)."},
+	{Name: "NHI_PARAMS_FILE", Description: "JSON or YAML file mapping the manifest's environment variables to values; explicit -e values take precedence."},
+	{Name: "NHI_PLAN_FORMAT", Description: "Format of the dry-run plan (text|json, same as --plan-format)."},
+	{Name: "NHI_REPORT_FILE", Description: "Write a JSON run report (exit code, duration, scalar outputs, errors) to this path."},
+	{Name: "NHI_SELFTEST", Description: "Set to 1 to run the manifest's examples against the fixtures under /examples and print a summary."},
+	{Name: "NHI_SELFTEST_FORMAT", Description: "Format of the self-test summary (tap|json, default tap)."},
+	{Name: "NHI_STAGE_OUTPUTS", Description: "Publish outputs only after a successful run (overrides runtime.stage_outputs)."},
+	{Name: "NHI_STRICT_ENV", Description: "Reject environment values that break their declared type or pattern (overrides runtime.strict_environment)."},
+	{Name: "NHI_TAR_MAX_ENTRIES", Description: "Maximum number of entries in the input tar (default 100000)."},
+	{Name: "NHI_TAR_MAX_SIZE", Description: "Maximum total file size in the input tar, e.g. 512M (default 1G)."},
+	{Name: "NHI_TIMEOUT", Description: "Maximum run time, e.g. 10m (overrides runtime.timeout)."},
+	{Name: "NHI_TMPDIR_MAX_SIZE", Description: "Size cap for NHI_TMPDIR, e.g. 512M (overrides runtime.tmpdir_max_size)."},
+	{Name: "SHOW_MANIFEST", Description: "Set to true to print the raw manifest.yml to stdout and exit."},
+}
+
+// buildHelp renders the manifest contract into a helpDoc. For a
+// multi-command reflex, sub selects the subcommand whose contract is shown;
+// when it is empty or unknown, only the contract shared by all subcommands is.
+func buildHelp(full manifesttypes.Manifest, sub string) *helpDoc {
+	m := full
+	if resolved, err := full.ForCommand(sub); err == nil {
+		m = resolved
+	} else {
+		sub = ""
+	}
+	d := &helpDoc{
+		Name:              m.Name,
+		Version:           m.Version,
+		Description:       strings.TrimSpace(m.Description),
+		Subcommand:        sub,
+		Usage:             usageLine,
+		Environment:       []helpEnv{},
+		Inputs:            []helpMount{},
+		Outputs:           []helpMount{},
+		ScalarOutputs:     []helpEnv{},
+		HelperEnvironment: helperEnvironment,
+	}
+	for _, name := range manifesttypes.SortedKeys(full.Commands) {
+		spec := full.Commands[name]
+		d.Commands = append(d.Commands, helpCommand{Name: name, Command: spec.Command, Description: strings.TrimSpace(spec.Description)})
+	}
+	if len(d.Commands) > 0 {
+		d.Usage = strings.Replace(usageLine, "<command>", "<subcommand>", 1)
+	}
+	for _, name := range manifesttypes.SortedKeys(m.Environment) {
+		spec := m.Environment[name]
+		d.Environment = append(d.Environment, helpEnv{
+			Name:        name,
+			Type:        typeOrString(spec.Type),
+			Description: spec.Description,
+			Required:    spec.Required,
+			Default:     spec.Default,
+			Pattern:     spec.Pattern,
+			Secret:      spec.Secret || secretNamePattern.MatchString(name),
+		})
+	}
+	mounts := func(specs map[string]manifesttypes.PathSpec, prefix string) []helpMount {
+		list := []helpMount{}
+		for _, name := range manifesttypes.SortedKeys(specs) {
+			spec := specs[name]
+			list = append(list, helpMount{
+				Name:        name,
+				Path:        filepath.Join(appIOBasePath, strings.ToLower(prefix)+"_"+name),
+				EnvVar:      prefix + "_" + strings.ToUpper(name),
+				Type:        spec.Type,
+				Description: spec.Description,
+				Required:    spec.Required,
+				Format:      spec.Format,
+				Pattern:     spec.Pattern,
+				Staging:     spec.Staging,
+			})
+		}
+		return list
+	}
+	d.Inputs = mounts(m.InputPaths, "INPUT")
+	d.Outputs = mounts(m.OutputPaths, "OUTPUT")
+	if m.Stdin != nil {
+		d.Stdin = &helpStdin{
+			Type:        m.Stdin.Type,
+			Format:      m.Stdin.Format,
+			Description: m.Stdin.Description,
+			Required:    m.Stdin.Required,
+			MaxSize:     m.Stdin.MaxSize,
+			Schema:      m.Stdin.Schema,
+		}
+	}
+	if m.Stdout != nil {
+		d.Stdout = &helpStdout{Type: m.Stdout.Type, Format: m.Stdout.Format, Description: m.Stdout.Description}
+	}
+	for _, name := range manifesttypes.SortedKeys(m.Outputs) {
+		spec := m.Outputs[name]
+		d.ScalarOutputs = append(d.ScalarOutputs, helpEnv{
+			Name:        name,
+			Type:        typeOrString(spec.Type),
+			Description: spec.Description,
+			Required:    spec.Required,
+			Pattern:     spec.Pattern,
+		})
+	}
+	d.Examples = helpExamples(d)
+	return d
+}
+
+// helpExamples builds example invocations: one with only what is required,
+// one with the full contract, and a dry run.
+func helpExamples(d *helpDoc) []string {
+	invocation := func(all bool, extra ...string) string {
+		parts := []string{"docker run --rm"}
+		parts = append(parts, extra...)
+		for _, e := range d.Environment {
+			if !all && !e.Required {
+				continue
+			}
+			if e.Secret {
+				// Passed through from the caller's environment, never on the command line
+				parts = append(parts, "-e "+e.Name)
+			} else {
+				parts = append(parts, fmt.Sprintf("-e %s=<%s>", e.Name, e.Type))
+			}
+		}
+		for _, mp := range d.Inputs {
+			if all || mp.Required {
+				parts = append(parts, fmt.Sprintf("-v /host/path/to/%s:%s:ro", mp.Name, mp.Path))
+			}
+		}
+		for _, mp := range d.Outputs {
+			if all || mp.Required {
+				parts = append(parts, fmt.Sprintf("-v /host/path/to/%s:%s", mp.Name, mp.Path))
+			}
+		}
+		parts = append(parts, "<image>")
+		if d.Subcommand != "" {
+			parts = append(parts, d.Subcommand)
+		} else if len(d.Commands) > 0 {
+			parts = append(parts, "<subcommand>")
+		}
+		return strings.Join(parts, " ")
+	}
+	owner := fmt.Sprintf("-e %s=$(id -u) -e %s=$(id -g)", manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv)
+	return []string{
+		invocation(false, owner),
+		invocation(true, owner),
+		invocation(false, "-e NHI_DRY_RUN=1"),
+	}
+}
+
+// writeHelp renders the manifest contract in format: text, markdown or json.
+// sub selects the subcommand of a multi-command reflex, as for buildHelp.
+// missingEnv, when set, lists required variables that are not set, and is
+// shown first in the text format.
+func writeHelp(w io.Writer, m manifesttypes.Manifest, sub, format string, missingEnv []string) error {
+	d := buildHelp(m, sub)
+	switch strings.ToLower(format) {
+	case "", "text":
+		writeHelpText(w, d, missingEnv)
+	case "markdown", "md":
+		writeHelpMarkdown(w, d)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false) // Keep <image> and friends readable
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return fmt.Errorf("failed to marshal help: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported help format: %s", format)
+	}
+	return nil
+}
+
+// envAttributes summarizes the constraints of an environment variable.
+func envAttributes(e helpEnv) string {
+	attrs := []string{e.Type}
+	if e.Required {
+		attrs = append(attrs, "required")
+	} else {
+		attrs = append(attrs, "optional")
+	}
+	if e.Default != "" {
+		attrs = append(attrs, "default "+e.Default)
+	}
+	if e.Pattern != "" {
+		attrs = append(attrs, "pattern "+e.Pattern)
+	}
+	if e.Secret {
+		attrs = append(attrs, "secret")
+	}
+	return strings.Join(attrs, ", ")
+}
+
+// mountAttributes summarizes the constraints of a mount.
+func mountAttributes(mp helpMount) string {
+	attrs := []string{mp.Type}
+	if mp.Required {
+		attrs = append(attrs, "required")
+	} else {
+		attrs = append(attrs, "optional")
+	}
+	if mp.Format != "" {
+		attrs = append(attrs, "format "+mp.Format)
+	}
+	if mp.Pattern != "" {
+		attrs = append(attrs, "pattern "+mp.Pattern)
+	}
+	if mp.Staging != "" {
+		attrs = append(attrs, "staging "+mp.Staging)
+	}
+	return strings.Join(attrs, ", ")
+}
+
+func writeHelpText(w io.Writer, d *helpDoc, missingEnv []string) {
+	fmt.Fprintf(w, "Usage: %s\n", d.Usage)
+	fmt.Fprintln(w, "-----------------------------------------------------------------")
+	if d.Name != "" {
+		fmt.Fprintf(w, "Reflex: %s", d.Name)
+		if d.Version != "" {
+			fmt.Fprintf(w, " (version %s)", d.Version)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w)
+	}
+	if d.Description != "" {
+		fmt.Fprintln(w, "Description:")
+		for _, line := range strings.Split(d.Description, "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(d.Commands) > 0 {
+		fmt.Fprintf(w, "Subcommands (first argument, or %s):\n", manifesttypes.CommandEnv)
+		for _, c := range d.Commands {
+			marker := ""
+			if c.Name == d.Subcommand {
+				marker = "  [shown below]"
+			}
+			fmt.Fprintf(w, "  %s%s\n", c.Name, marker)
+			if c.Description != "" {
+				fmt.Fprintf(w, "      %s\n", c.Description)
+			}
+		}
+		if d.Subcommand == "" {
+			fmt.Fprintln(w, "  Run --help <subcommand> for the full contract of a subcommand; shown below is what they share.")
+		}
+		fmt.Fprintln(w)
+	}
+
+	// Listed first when we are exiting because of them
+	if len(missingEnv) > 0 {
+		fmt.Fprintln(w, "Missing Required Environment Variables (must be set via -e or similar):")
+		for _, desc := range missingEnv {
+			fmt.Fprintln(w, desc)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(d.Environment) > 0 {
+		fmt.Fprintln(w, "Environment Variables:")
+		for _, e := range d.Environment {
+			fmt.Fprintf(w, "  %s (%s)\n", e.Name, envAttributes(e))
+			if e.Description != "" {
+				fmt.Fprintf(w, "      %s\n", e.Description)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	writeMounts := func(title, suffix string, mounts []helpMount) {
+		if len(mounts) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", title)
+		for _, mp := range mounts {
+			fmt.Fprintf(w, "  -v /host/path/to/%s:%s%s  (%s)\n", mp.Name, mp.Path, suffix, mountAttributes(mp))
+			if mp.Description != "" {
+				fmt.Fprintf(w, "      %s\n", mp.Description)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	writeMounts("Inputs (mounted read-only)", ":ro", d.Inputs)
+	writeMounts("Outputs (mounted read-write)", "", d.Outputs)
+
+	if d.Stdin != nil {
+		fmt.Fprintln(w, "Standard Input (docker run -i):")
+		fmt.Fprintf(w, "  %s\n", strings.Join(stdinAttributes(d.Stdin), ", "))
+		if d.Stdin.Description != "" {
+			fmt.Fprintf(w, "      %s\n", d.Stdin.Description)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if d.Stdout != nil {
+		fmt.Fprintln(w, "Standard Output:")
+		fmt.Fprintf(w, "  %s\n", strings.Join(nonEmpty(d.Stdout.Type, d.Stdout.Format), ", "))
+		if d.Stdout.Description != "" {
+			fmt.Fprintf(w, "      %s\n", d.Stdout.Description)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(d.ScalarOutputs) > 0 {
+		fmt.Fprintln(w, "Scalar Outputs (key=value lines written to $NHI_OUTPUTS):")
+		for _, e := range d.ScalarOutputs {
+			fmt.Fprintf(w, "  %s (%s)\n", e.Name, envAttributes(e))
+			if e.Description != "" {
+				fmt.Fprintf(w, "      %s\n", e.Description)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "Examples:")
+	for _, ex := range d.Examples {
+		fmt.Fprintf(w, "  %s\n", ex)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Helper Environment Variables:")
+	for _, e := range d.HelperEnvironment {
+		fmt.Fprintf(w, "  %s: %s\n", e.Name, e.Description)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Arguments:")
+	if len(d.Commands) > 0 {
+		fmt.Fprintln(w, "  <subcommand> [args...] : One of the subcommands above; the arguments are passed on to its command.")
+		return
+	}
+	fmt.Fprintln(w, "  <command> [args...] : The command and arguments the reflex should execute.")
+}
+
+func writeHelpMarkdown(w io.Writer, d *helpDoc) {
+	title := d.Name
+	if title == "" {
+		title = "Reflex"
+	}
+	fmt.Fprintf(w, "# %s\n\n", title)
+	if d.Version != "" {
+		fmt.Fprintf(w, "Version: %s\n\n", d.Version)
+	}
+	if d.Description != "" {
+		fmt.Fprintf(w, "%s\n\n", d.Description)
+	}
+	fmt.Fprintf(w, "```\n%s\n```\n\n", d.Usage)
+
+	if len(d.Commands) > 0 {
+		fmt.Fprintln(w, "## Subcommands")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Selected by the first argument or `%s`.", manifesttypes.CommandEnv)
+		if d.Subcommand != "" {
+			fmt.Fprintf(w, " The contract below is that of `%s`.", d.Subcommand)
+		} else {
+			fmt.Fprint(w, " The contract below is the one all subcommands share; `--help <subcommand>` shows the full contract of one.")
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Name | Command | Description |")
+		fmt.Fprintln(w, "|------|---------|-------------|")
+		for _, c := range d.Commands {
+			fmt.Fprintf(w, "| `%s` | %s | %s |\n", c.Name, mdCode(strings.Join(c.Command, " ")), mdCell(c.Description))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(d.Environment) > 0 {
+		fmt.Fprintln(w, "## Environment Variables")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Name | Type | Required | Default | Pattern | Secret | Description |")
+		fmt.Fprintln(w, "|------|------|----------|---------|---------|--------|-------------|")
+		for _, e := range d.Environment {
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s | %s | %s |\n",
+				e.Name, e.Type, yesNo(e.Required), mdCode(e.Default), mdCode(e.Pattern), yesNo(e.Secret), mdCell(e.Description))
+		}
+		fmt.Fprintln(w)
+	}
+
+	writeMounts := func(title string, mounts []helpMount) {
+		if len(mounts) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "## %s\n\n", title)
+		fmt.Fprintln(w, "| Name | Path | Variable | Type | Required | Format | Description |")
+		fmt.Fprintln(w, "|------|------|----------|------|----------|--------|-------------|")
+		for _, mp := range mounts {
+			fmt.Fprintf(w, "| %s | `%s` | `%s` | %s | %s | %s | %s |\n",
+				mp.Name, mp.Path, mp.EnvVar, mp.Type, yesNo(mp.Required), mdCell(mp.Format), mdCell(mp.Description))
+		}
+		fmt.Fprintln(w)
+	}
+	writeMounts("Inputs (read-only)", d.Inputs)
+	writeMounts("Outputs (read-write)", d.Outputs)
+
+	if d.Stdin != nil {
+		fmt.Fprintln(w, "## Standard Input")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s\n\n", strings.Join(stdinAttributes(d.Stdin), ", "))
+		if d.Stdin.Description != "" {
+			fmt.Fprintf(w, "%s\n\n", d.Stdin.Description)
+		}
+		if d.Stdin.Schema != nil {
+			if data, err := json.MarshalIndent(d.Stdin.Schema, "", "  "); err == nil {
+				fmt.Fprintf(w, "```json\n%s\n```\n\n", data)
+			}
+		}
+	}
+
+	if d.Stdout != nil {
+		fmt.Fprintln(w, "## Standard Output")
+		fmt.Fprintln(w)
+		if attrs := nonEmpty(d.Stdout.Type, d.Stdout.Format); len(attrs) > 0 {
+			fmt.Fprintf(w, "%s\n\n", strings.Join(attrs, ", "))
+		}
+		if d.Stdout.Description != "" {
+			fmt.Fprintf(w, "%s\n\n", d.Stdout.Description)
+		}
+	}
+
+	if len(d.ScalarOutputs) > 0 {
+		fmt.Fprintln(w, "## Scalar Outputs")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Written by the reflex as `key=value` lines to `$NHI_OUTPUTS` and reported in the run report.")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Name | Type | Required | Pattern | Description |")
+		fmt.Fprintln(w, "|------|------|----------|---------|-------------|")
+		for _, e := range d.ScalarOutputs {
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n", e.Name, e.Type, yesNo(e.Required), mdCode(e.Pattern), mdCell(e.Description))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "## Examples")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "```sh")
+	for _, ex := range d.Examples {
+		fmt.Fprintln(w, ex)
+	}
+	fmt.Fprintln(w, "```")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "## Helper Environment Variables")
+	fmt.Fprintln(w)
+	for _, e := range d.HelperEnvironment {
+		fmt.Fprintf(w, "- `%s`: %s\n", e.Name, e.Description)
+	}
+}
+
+// stdinAttributes summarizes the stdin contract for the text and markdown
+// renderings.
+func stdinAttributes(s *helpStdin) []string {
+	attrs := nonEmpty(s.Type, s.Format)
+	if s.Required {
+		attrs = append(attrs, "required")
+	} else {
+		attrs = append(attrs, "optional")
+	}
+	if s.MaxSize != "" {
+		attrs = append(attrs, "max "+s.MaxSize)
+	}
+	if s.Schema != nil {
+		attrs = append(attrs, "schema-validated")
+	}
+	return attrs
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// mdCode formats a value as inline code, leaving empty values empty.
+func mdCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + mdCell(s) + "`"
+}
+
+// mdCell keeps a value on one table row.
+func mdCell(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "\n", " ")
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// nonEmpty returns the non-empty strings among values.
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"nhi/basetools/internal/testutil"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestWriteHelp(t *testing.T) {
+	data, err := os.ReadFile(testutil.Fixture("manifest.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifesttypes.Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		format     string
+		missingEnv []string
+		golden     string
+	}{
+		{name: "text", format: "text", golden: "help.txt"},
+		{name: "text with missing variables", format: "text", missingEnv: []string{"TARGET_FORMAT"}, golden: "help-missing.txt"},
+		{name: "markdown", format: "markdown", golden: "help.md"},
+		{name: "json", format: "json", golden: "help.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			if err := writeHelp(&sb, m, "", tt.format, tt.missingEnv); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckGolden(t, filepath.Join("testdata", tt.golden+".golden"), sb.String())
+		})
+	}
+}
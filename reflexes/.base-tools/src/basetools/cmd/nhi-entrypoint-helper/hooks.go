@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Hook phases
+const (
+	hookPre  = "pre"  // Before the command
+	hookPost = "post" // After the command, while the run is still successful
+)
+
+// hookPlan is a resolved manifest hook.
+type hookPlan struct {
+	Phase     string   `json:"phase"`
+	Name      string   `json:"name"`
+	Command   []string `json:"command"`
+	OnFailure string   `json:"on_failure"`
+	Timeout   string   `json:"timeout,omitempty"`
+
+	timeout time.Duration // 0 means no timeout
+}
+
+// label names the hook in messages.
+func (h hookPlan) label() string {
+	return fmt.Sprintf("Hook '%s' (%s)", h.Name, h.Phase)
+}
+
+// planHooks resolves the manifest's hooks, pre hooks first. A hook without
+// its own timeout gets the run's. Problems are returned rather than acted
+// on, like the rest of the plan.
+func planHooks(spec *manifesttypes.HooksSpec, runTimeout time.Duration) ([]hookPlan, []*helperError) {
+	if spec == nil {
+		return nil, nil
+	}
+	var hooks []hookPlan
+	var errs []*helperError
+	add := func(phase string, specs []manifesttypes.HookSpec) {
+		for i, hs := range specs {
+			h := hookPlan{
+				Phase:     phase,
+				Name:      hs.Name,
+				Command:   append([]string(nil), hs.Command...),
+				OnFailure: strings.ToLower(hs.OnFailure),
+				timeout:   runTimeout,
+			}
+			if h.Name == "" {
+				h.Name = fmt.Sprintf("%s[%d]", phase, i)
+			}
+			switch h.OnFailure {
+			case "":
+				h.OnFailure = manifesttypes.HookAbort
+			case manifesttypes.HookAbort, manifesttypes.HookFail, manifesttypes.HookIgnore:
+			default:
+				errs = append(errs, newError(categoryUsage, h.Name, "Use on_failure: abort, fail or ignore",
+					"%s: invalid on_failure %q", h.label(), hs.OnFailure))
+			}
+			if hs.Timeout != "" {
+				d, err := time.ParseDuration(hs.Timeout)
+				if err != nil || d <= 0 {
+					errs = append(errs, newError(categoryUsage, h.Name, "Use a duration such as 90s or 10m",
+						"%s: invalid timeout %q", h.label(), hs.Timeout))
+				} else {
+					h.timeout = d
+				}
+			}
+			if h.timeout > 0 {
+				h.Timeout = h.timeout.String()
+			}
+			if len(h.Command) == 0 {
+				errs = append(errs, newError(categoryUsage, h.Name, "Give the hook a command in manifest.yml", "%s has no command", h.label()))
+			} else if resolved, err := exec.LookPath(h.Command[0]); err != nil {
+				errs = append(errs, newError(categoryCommandNotFound, h.Name, "Install the hook's command in the image or fix it in manifest.yml",
+					"%s: failed to find command '%s' in PATH: %v", h.label(), h.Command[0], err))
+			} else {
+				h.Command[0] = resolved
+			}
+			hooks = append(hooks, h)
+		}
+	}
+	add(hookPre, spec.Pre)
+	add(hookPost, spec.Post)
+	return hooks, errs
+}
+
+// hookResult is the outcome of one hook, as included in the run report.
+type hookResult struct {
+	Phase     string        `json:"phase"`
+	Name      string        `json:"name"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"duration_ns"`
+	OnFailure string        `json:"on_failure"`
+	Error     *helperError  `json:"error,omitempty"` // Set when the helper failed or stopped the hook
+}
+
+// hookRunner runs hooks the way the reflex command is run: same environment,
+// identity, workspace and progress channel. Hook stdout goes to stderr, so
+// stdout carries the reflex's output only.
+type hookRunner struct {
+	logger    *slog.Logger
+	env       []string
+	id        *identity
+	workspace *workspace
+	progress  *os.File
+	Results   []hookResult
+	Errors    []*helperError // Helper errors of failed hooks that fail the run
+}
+
+// run runs the hooks of phase in order. It returns the exit code of the
+// first failed hook whose policy fails the run (0 if none did), and whether
+// the run must stop because that policy is abort.
+func (r *hookRunner) run(hooks []hookPlan, phase string) (int, bool) {
+	exitCode := 0
+	for _, h := range hooks {
+		if h.Phase != phase {
+			continue
+		}
+		r.logger.Info("Running hook", "phase", h.Phase, "hook", h.Name, "cmd", h.Command)
+		start := time.Now()
+		limits := runLimits{Timeout: h.timeout, Workspace: r.workspace, Label: h.label()}
+		code, herr := executeCommand(r.logger, h.Command[0], h.Command, r.env, r.id, limits, reflexIO{Stdout: os.Stderr, Progress: r.progress})
+		r.Results = append(r.Results, hookResult{
+			Phase: h.Phase, Name: h.Name, ExitCode: code, Duration: time.Since(start), OnFailure: h.OnFailure, Error: herr,
+		})
+		if code == 0 {
+			continue
+		}
+		if h.OnFailure == manifesttypes.HookIgnore {
+			r.logger.Warn("Hook failed; ignoring", "phase", h.Phase, "hook", h.Name, "exit_code", code)
+			continue
+		}
+		r.logger.Error("Hook failed", "phase", h.Phase, "hook", h.Name, "exit_code", code, "on_failure", h.OnFailure)
+		if herr != nil {
+			r.Errors = append(r.Errors, herr)
+		}
+		if exitCode == 0 {
+			exitCode = code
+		}
+		if h.OnFailure == manifesttypes.HookAbort {
+			return exitCode, true
+		}
+	}
+	return exitCode, false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// stageInputs materializes a private writable copy of every input whose
+// PathSpec asks for `staging: copy`. The copies are created below dir, which
+// is created on first use and handed to the reflex identity. It returns the
+// staged path for each input name.
+func stageInputs(specs map[string]manifesttypes.PathSpec, inputs map[string]string, dir string, id *identity) (map[string]string, error) {
+	staged := make(map[string]string)
+	for _, name := range manifesttypes.SortedKeys(inputs) {
+		switch specs[name].Staging {
+		case "", manifesttypes.StagingNone:
+			continue
+		case manifesttypes.StagingCopy:
+		default:
+			return staged, fmt.Errorf("input '%s': unsupported staging mode %q", name, specs[name].Staging)
+		}
+		if len(staged) == 0 {
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				return staged, fmt.Errorf("creating input staging directory: %w", err)
+			}
+			if id != nil {
+				if err := os.Chown(dir, int(id.UID), int(id.GID)); err != nil {
+					return staged, fmt.Errorf("handing input staging directory to reflex user: %w", err)
+				}
+			}
+		}
+		dst := filepath.Join(dir, "input_"+name)
+		if err := copyTree(inputs[name], dst, id); err != nil {
+			return staged, fmt.Errorf("staging input '%s': %w", name, err)
+		}
+		staged[name] = dst
+	}
+	return staged, nil
+}
+
+// copyTree copies src to dst. Directories are walked in lexical order so
+// copies are deterministic, symlinks are recreated rather than followed, and
+// permission bits are preserved with owner write access added so the copy
+// is writable. Each created entry is handed to id when it is non-nil.
+func copyTree(src, dst string, id *identity) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+
+		switch {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0o700); err != nil {
+				return err
+			}
+			// Keep the source bits, but the owner must be able to write into the copy
+			if err := os.Chmod(target, mode.Perm()|0o700); err != nil {
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := copyFile(path, target, mode.Perm()|0o200); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported file type at %s", path)
+		}
+
+		if id != nil {
+			if err := os.Lchown(target, int(id.UID), int(id.GID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copyFile copies the contents of a regular file to a new file with perm.
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile's perm is subject to the umask; apply the exact bits.
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// newLogger builds the helper's logger from NHI_LOG_LEVEL (debug, info, warn
+// or error; default warn), NHI_LOG_FORMAT (text or json) and NHI_LOG_FILE
+// (default stderr). Every record carries runID so helper logs can be matched
+// with the reflex's own output, which sees the same id as NHI_RUN_ID.
+func newLogger(runID string) (*slog.Logger, *helperError) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOr("NHI_LOG_LEVEL", "warn"))); err != nil {
+		return nil, newError(categoryUsage, "", "Set NHI_LOG_LEVEL to debug, info, warn or error", "NHI_LOG_LEVEL: %v", err)
+	}
+
+	var w io.Writer = os.Stderr
+	if path := os.Getenv("NHI_LOG_FILE"); path != "" {
+		// Writes go straight to the file, so nothing is lost on os.Exit
+		f, err := openLogFile(path)
+		if err != nil {
+			return nil, newError(categoryUsage, "", "Point NHI_LOG_FILE at a writable file, e.g. in a mounted output directory", "NHI_LOG_FILE: %v", err)
+		}
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := envOr("NHI_LOG_FORMAT", "text"); strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, newError(categoryUsage, "", "Set NHI_LOG_FORMAT to text or json", "NHI_LOG_FORMAT: unsupported format %q (want text or json)", format)
+	}
+	return slog.New(handler).With("run_id", runID), nil
+}
+
+// openLogFile opens path for appending. A file the helper creates as root is
+// handed to CALLING_UID/CALLING_GID, like outputs; an existing file keeps its
+// owner. Invalid CALLING_* values are reported once the run is planned.
+func openLogFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+	if os.IsExist(err) {
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner, _ := resolveCallingOwner(); owner != nil && os.Geteuid() == 0 {
+		if err := f.Chown(owner.UID, owner.GID); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("handing %s to %s/%s: %w", path, manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv, err)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestNewLoggerErrorsNameTheVariable(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		wantV string
+	}{
+		{name: "level", env: map[string]string{"NHI_LOG_LEVEL": "loud"}, wantV: "NHI_LOG_LEVEL"},
+		{name: "format", env: map[string]string{"NHI_LOG_FORMAT": "xml"}, wantV: "NHI_LOG_FORMAT"},
+		{name: "file", env: map[string]string{"NHI_LOG_FILE": filepath.Join(t.TempDir(), "missing", "helper.log")}, wantV: "NHI_LOG_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := newLogger("run")
+			if err == nil {
+				t.Fatal("newLogger() succeeded")
+			}
+			if !strings.HasPrefix(err.Message, tt.wantV) || !strings.Contains(err.Hint, tt.wantV) || err.Category != categoryUsage {
+				t.Fatalf("error %+v does not point at %s", *err, tt.wantV)
+			}
+		})
+	}
+}
+
+// A log file the helper creates goes to the caller like outputs do; an
+// existing file keeps its owner.
+func TestOpenLogFileOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("handing the log file over needs root")
+	}
+	t.Setenv(manifesttypes.CallingUIDEnv, "65534")
+	t.Setenv(manifesttypes.CallingGIDEnv, "65534")
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.log")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantUID uint32
+	}{
+		{path: filepath.Join(dir, "new.log"), wantUID: 65534},
+		{path: existing, wantUID: 0},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			f, err := openLogFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uid := info.Sys().(*syscall.Stat_t).Uid; uid != tt.wantUID {
+				t.Fatalf("owner = %d, want %d", uid, tt.wantUID)
+			}
+		})
+	}
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
 	// Import the shared types from the internal package
+	"nhi/basetools/pkg/buildinfo"
+	"nhi/basetools/pkg/examples"
 	"nhi/basetools/pkg/manifesttypes"
 )
 
@@ -21,18 +25,51 @@ const manifestPath = "/manifest.yml"
 // Base path for mounted inputs/outputs
 const appIOBasePath = "/app"
 
+// ioBasePath returns the directory holding the input_* and output_* mounts.
+// NHI_IO_BASE overrides it so examples can run against fixture copies.
+func ioBasePath() string {
+	return envOr(examples.IOBaseEnv, appIOBasePath)
+}
+
 // --- Helper Logic ---
 
 func main() {
+	errorFormat = envOr("NHI_ERROR_FORMAT", "text")
+
 	// --- Logger Setup ---
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	// The run id is generated first so every log record can carry it
+	runID, err := newRunID()
+	if err != nil {
+		fail(internalError(err))
+	}
+	logger, logErr := newLogger(runID)
+	if logErr != nil {
+		fail(logErr)
+	}
 
 	// --- Flag Definition ---
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(io.Discard) // Parse errors are reported as helper errors
 	showHelpShort := flag.Bool("h", false, "Show help message")
 	showHelpLong := flag.Bool("help", false, "Show help message")
-	flag.Parse() // Parse command-line flags
+	helpFormat := flag.String("format", "text", "Format of the --help output: text, markdown or json")
+	dryRunFlag := flag.Bool("dry-run", false, "Validate and print the invocation plan without executing anything")
+	planFormat := flag.String("plan-format", envOr("NHI_PLAN_FORMAT", "text"), "Format of the --dry-run plan: text or json")
+	noCacheFlag := flag.Bool("no-cache", false, "Run the reflex even if NHI_CACHE_DIR holds a cached result")
+	cacheStatsFlag := flag.Bool("cache-stats", false, "Print statistics about the result cache in NHI_CACHE_DIR and exit")
+	versionFlag := flag.Bool("version", false, "Print the basetools version and exit")
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil { // Parse command-line flags
+		fail(newError(categoryUsage, "", "Run with --help to list the supported flags", "%v", err))
+	}
+	dryRun := *dryRunFlag || envBool("NHI_DRY_RUN", false)
+	noCache := *noCacheFlag || envBool("NHI_NO_CACHE", false)
 
-	// --- Early Exits: SHOW_MANIFEST or Help Flags ---
+	// --- Early Exits: --version, SHOW_MANIFEST or Help Flags ---
+
+	if *versionFlag {
+		fmt.Println(buildinfo.String("nhi-entrypoint-helper"))
+		os.Exit(0)
+	}
 
 	// Check for SHOW_MANIFEST first
 	if strings.ToLower(os.Getenv("SHOW_MANIFEST")) == "true" {
@@ -50,126 +87,422 @@ func main() {
 		} else {
 			fmt.Fprintf(os.Stderr, "Warning: Could not read manifest %s for help: %v\n", manifestPath, err)
 		}
-		printUsage(m, nil) // Pass empty slice for required envs when just showing help
+		sub := "" // Help for a subcommand: --help <subcommand>, or NHI_COMMAND
+		if len(m.Commands) > 0 {
+			sub, _ = subcommandName(m, flag.Args())
+		}
+		if err := writeHelp(os.Stdout, m, sub, *helpFormat, nil); err != nil {
+			fail(newError(categoryUsage, "", "Use --format=text, --format=markdown or --format=json", "%v", err))
+		}
+		os.Exit(0)
+	}
+
+	// Report on the result cache
+	if *cacheStatsFlag {
+		settings, err := resolveRunSettings(nil) // Cache settings come from the environment only
+		if err == nil && settings.CacheDir == "" {
+			err = fmt.Errorf("NHI_CACHE_DIR is not set")
+		}
+		if err != nil {
+			fail(newError(categoryUsage, "", "Set NHI_CACHE_DIR to the cache directory", "%v", err))
+		}
+		cache := &resultCache{Dir: settings.CacheDir, MaxBytes: settings.CacheMaxBytes}
+		if err := cache.writeStats(os.Stdout); err != nil {
+			fail(internalError(err))
+		}
 		os.Exit(0)
 	}
 
+	// Run the manifest's examples instead of the reflex
+	if envBool("NHI_SELFTEST", false) {
+		os.Exit(runSelfTest(logger, flag.Args(), envOr("NHI_SELFTEST_FORMAT", "tap")))
+	}
+
 	// --- Regular Execution Logic --- //
 
 	// --- Get Command Args ---
-	if flag.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Error: No command provided to the entrypoint helper.")
-		printUsage(manifesttypes.Manifest{}, nil)
-		os.Exit(1)
+	// A multi-command reflex can be run without arguments when NHI_COMMAND
+	// selects the subcommand
+	if flag.NArg() < 1 && os.Getenv(manifesttypes.CommandEnv) == "" {
+		failNoCommand(manifesttypes.Manifest{})
 	}
 	targetCmdArgs := flag.Args()
-	targetCmdPath := targetCmdArgs[0]
+
+	// Resolve who outputs should be handed back to before doing any work
+	owner, err := resolveCallingOwner()
+	if err != nil {
+		fail(newError(categoryUsage, "", "Pass numeric IDs, e.g. -e CALLING_UID=$(id -u) -e CALLING_GID=$(id -g)", "%v", err))
+	}
 
 	// Read and parse manifest (required for validation and env export)
 	manifestData, err := os.ReadFile(manifestPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading manifest %s: %v\n", manifestPath, err)
 		// Still attempt execution if manifest is unreadable, as per original logic
-		executeCommand(logger, targetCmdPath, targetCmdArgs, os.Environ()) // Pass original env
-		return
+		runWithoutManifest(logger, owner, runID, targetCmdArgs)
 	}
 
 	var m manifesttypes.Manifest
 	if err := yaml.Unmarshal(manifestData, &m); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not parse manifest %s: %v\n", manifestPath, err)
 		// Still attempt execution if manifest is unparseable
-		executeCommand(logger, targetCmdPath, targetCmdArgs, os.Environ()) // Pass original env
-		return
+		runWithoutManifest(logger, owner, runID, targetCmdArgs)
+	}
+	if herr := checkBasetools(logger, m); herr != nil {
+		fail(herr)
 	}
 
-	// Prepare environment variables
-	envVars := os.Environ() // Start with current environment
-	exportedEnvVars := []string{} // Track vars added by helper
-	validatedInputPaths := make(map[string]string)
-	validatedOutputPaths := make(map[string]string)
-
-	// --- Validate Inputs/Outputs and Prepare Env Vars (using parsed manifest 'm') ---
-	logger.Info("Validating manifest inputs...")
-	for name := range m.InputPaths {
-		inputPath := filepath.Join(appIOBasePath, "input_"+name)
-		logger.Info("Checking input", "name", name, "path", inputPath)
-		_, err := os.Stat(inputPath) // Validation still happens as the process user
-		if err != nil {
-			if os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Error: Required input '%s' not found at expected path: %s\n", name, inputPath)
-			} else {
-				fmt.Fprintf(os.Stderr, "Error checking input path %s for '%s': %v\n", inputPath, name, err)
+	// --- Subcommand Dispatch (manifest commands:) --- //
+	// From here on, m is the contract of the selected subcommand and the
+	// command line is the subcommand's, followed by the remaining arguments.
+	fullManifest := m
+	subcommand := ""
+	if len(m.Commands) > 0 {
+		var cerr *helperError
+		if subcommand, m, targetCmdArgs, cerr = selectSubcommand(fullManifest, targetCmdArgs); cerr != nil {
+			if errorFormat == "text" {
+				printUsage(fullManifest, "", nil)
 			}
-			os.Exit(1)
+			fail(cerr)
 		}
-		envVarName := "INPUT_" + strings.ToUpper(name)
-		envVar := fmt.Sprintf("%s=%s", envVarName, inputPath)
-		exportedEnvVars = append(exportedEnvVars, envVar)
-		validatedInputPaths[envVarName] = inputPath
-	}
-	logger.Info("Validating manifest outputs...")
-	for name := range m.OutputPaths {
-		outputPath := filepath.Join(appIOBasePath, "output_"+name)
-		logger.Info("Checking output", "name", name, "path", outputPath)
-		info, err := os.Stat(outputPath) // Validation still happens as the process user
-		if err != nil {
-			if os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Error: Required output directory '%s' not found at expected path: %s\n", name, outputPath)
-			} else {
-				fmt.Fprintf(os.Stderr, "Error checking output path %s for '%s': %v\n", outputPath, name, err)
-			}
-			os.Exit(1)
+		logger.Info("Selected subcommand", "subcommand", subcommand)
+	} else if len(targetCmdArgs) == 0 {
+		failNoCommand(m)
+	}
+
+	// --- Signal Handling --- //
+	// From here on, everything the run creates is registered for removal if
+	// the helper is stopped by a signal while no reflex is running.
+	runGuard = installSignalGuard(logger)
+
+	// --- Tar I/O (NHI_IO=tar) --- //
+	// Inputs arrive as a tar on stdin and are extracted to a private IO base
+	// that takes the place of /app; outputs leave as a tar on stdout.
+	ioMode, err := resolveIOMode()
+	if err != nil {
+		fail(newError(categoryUsage, "", "Set NHI_IO to mount or tar", "%v", err))
+	}
+	var tio *tarIO
+	if ioMode == ioModeTar {
+		tio = receiveTarInputs(m, owner)
+		runGuard.onInterrupt(func() { tio.cleanup(logger) })
+		os.Setenv(examples.IOBaseEnv, tio.Base)
+	}
+
+	// --- Build the Invocation Plan (validation only, no side effects) --- //
+	logger.Info("Validating manifest inputs, outputs and environment...")
+	plan := buildPlan(m, targetCmdArgs, owner, runID)
+	plan.Subcommand = subcommand
+	for _, msg := range plan.Warnings {
+		logger.Warn(msg)
+	}
+
+	if dryRun {
+		if err := writePlan(os.Stdout, plan, *planFormat); err != nil {
+			fail(newError(categoryUsage, "", "Use --plan-format=text or --plan-format=json", "%v", err))
 		}
-		if !info.IsDir() {
-			fmt.Fprintf(os.Stderr, "Error: Output path '%s' (%s) is not a directory.\n", name, outputPath)
-			os.Exit(1)
+		tio.cleanup(logger)
+		os.Exit(exitCodeFor(plan.Errors))
+	}
+
+	if len(plan.Errors) > 0 {
+		tio.cleanup(logger)
+		if len(plan.missingEnv) > 0 && errorFormat == "text" {
+			printUsage(fullManifest, subcommand, plan.missingEnv) // Print usage with specific missing vars
+			fmt.Fprintln(os.Stderr, "")
 		}
-		// Perform write test (should work if --user flag was correct)
-		tempFile, err := os.CreateTemp(outputPath, ".writetest-")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Output directory '%s' (%s) is not writable by current user (UID: %d, GID: %d): %v\n", name, outputPath, os.Geteuid(), os.Getegid(), err)
-			os.Exit(1)
+		fail(plan.Errors...)
+	}
+	reflexID := plan.identity
+	settings := plan.settings
+
+	// --- Privileged Preparation (while still root) --- //
+	if err := prepareOutputs(reflexID, m.OutputPaths); err != nil {
+		fail(internalError(err))
+	}
+
+	// Prepare environment variables
+	envVars := os.Environ()                 // Start with current environment
+	exportedEnvVars := plan.exports()       // Track vars added by helper
+	inputMounts := make(map[string]string)  // Input name -> mount path
+	outputMounts := make(map[string]string) // Output name -> mount path
+	for _, mp := range plan.Inputs {
+		if mp.Found {
+			inputMounts[mp.Name] = mp.Path
 		}
-		tempFile.Close()
-		os.Remove(tempFile.Name())
-		envVarName := "OUTPUT_" + strings.ToUpper(name)
-		envVar := fmt.Sprintf("%s=%s", envVarName, outputPath)
-		exportedEnvVars = append(exportedEnvVars, envVar)
-		validatedOutputPaths[envVarName] = outputPath
 	}
-	logger.Info("Exporting derived environment variables:")
-	for k, v := range validatedInputPaths {
-		logger.Info("  Exporting", "var", k, "value", v)
-	}
-	for k, v := range validatedOutputPaths {
-		logger.Info("  Exporting", "var", k, "value", v)
-	}
-
-	// --- Check Required Environment Variables (from manifest 'environment' section) ---
-	missingRequired := false
-	requiredInputsDesc := []string{}
-	for name, spec := range m.Environment {
-		if spec.Required {
-			val := os.Getenv(name) // Check original env
-			if val == "" {
-				missingRequired = true
-				desc := fmt.Sprintf("  - %s: %s", name, spec.Description)
-				requiredInputsDesc = append(requiredInputsDesc, desc)
+	for _, mp := range plan.Outputs {
+		outputMounts[mp.Name] = mp.Path
+	}
+
+	// --- Stdin Contract (manifest stdin:) --- //
+	// Applied before the cache lookup, since buffered stdin is part of the key
+	stdinReader := io.Reader(os.Stdin)
+	if tio != nil {
+		if stdinReader, err = tio.stdin(); err != nil {
+			tio.cleanup(logger)
+			fail(internalError(err))
+		}
+	}
+	stdin, serr := openStdin(m.Stdin, stdinReader)
+	if serr != nil {
+		tio.cleanup(logger)
+		fail(serr)
+	}
+	runGuard.onInterrupt(stdin.close)
+
+	// Summary of the run for NHI_REPORT_FILE and the output tar
+	report := &runReport{RunID: runID, Reflex: m.Name, Version: m.Version, Subcommand: subcommand,
+		Parameters: plan.parameterSources(), StartedAt: time.Now()}
+
+	// --- Result Cache (opt-in via NHI_CACHE_DIR) --- //
+	// A run with the same key has succeeded before: replay its result.
+	var cache *resultCache
+	var key string
+	if settings.CacheDir != "" && !noCache {
+		if key, err = cacheKey(manifestData, plan, stdin); err != nil {
+			logger.Warn("Not using the result cache", "error", err)
+		} else {
+			cache = &resultCache{Dir: settings.CacheDir, MaxBytes: settings.CacheMaxBytes}
+		}
+	}
+	if cache != nil && cache.lookup(key) {
+		logger.Info("Cache hit; replaying the cached result instead of executing", "key", key)
+		var errs []*helperError
+		runGuard.enterCritical()
+		values, err := cache.replay(logger, key, outputMounts, settings, runID, reflexID, reflexStdout(tio))
+		runGuard.leaveCritical()
+		if err != nil {
+			errs = append(errs, internalError(err))
+		}
+		cache.updateStats(cacheStats{Hits: 1})
+		if tio == nil {
+			if err := chownOutputs(logger, outputMounts, owner); err != nil {
+				errs = append(errs, internalError(err))
 			}
 		}
+		report.Cached, report.Outputs, report.Errors = true, values, errs
+		report.ExitCode, report.Duration = exitCodeFor(errs), time.Since(report.StartedAt)
+		errs = append(errs, deliverResults(logger, report, tio, m.OutputPaths, owner)...)
+		stdin.close()
+		if len(errs) > 0 {
+			fail(errs...)
+		}
+		os.Exit(0)
 	}
 
-	if missingRequired {
-		fmt.Fprintln(os.Stderr, "\nError: Missing required environment variables.")
-		printUsage(m, requiredInputsDesc) // Print usage with specific missing vars
-		os.Exit(1)
+	// --- Create the Per-Run Workspace --- //
+	// Exported as NHI_TMPDIR/TMPDIR and removed when the run ends, including
+	// after a timeout or a forwarded signal.
+	ws, err := newWorkspace(runID, settings.TmpMaxBytes, reflexID)
+	if err != nil {
+		fail(internalError(err))
+	}
+	runGuard.onInterrupt(func() { ws.cleanup(logger, false) })
+	exportedEnvVars = append(exportedEnvVars, ws.env()...)
+
+	// --- Stage Inputs (PathSpec staging: copy) --- //
+	// INPUT_<NAME> keeps pointing at the read-only mount; the writable copy is
+	// exported as INPUT_<NAME>_STAGED.
+	stagedInputs, err := stageInputs(m.InputPaths, inputMounts, ws.InputsDir, reflexID)
+	if err != nil {
+		ws.cleanup(logger, false)
+		fail(internalError(err))
+	}
+	for _, name := range manifesttypes.SortedKeys(stagedInputs) {
+		envVarName := "INPUT_" + strings.ToUpper(name) + "_STAGED"
+		exportedEnvVars = append(exportedEnvVars, fmt.Sprintf("%s=%s", envVarName, stagedInputs[name]))
+	}
+
+	// --- Stage Outputs (optional) --- //
+	// With staging enabled, OUTPUT_* point at a directory inside each mount and
+	// the content is only swapped into the mount after a successful run.
+	var stages []outputStage
+	if settings.StageOutputs {
+		runGuard.onInterrupt(func() { _ = discardOutputs(logger, stages, runID, false) })
+		stages, err = createOutputStages(outputMounts, runID, reflexID)
+		if err != nil {
+			_ = discardOutputs(logger, stages, runID, false)
+			ws.cleanup(logger, false)
+			fail(internalError(err))
+		}
+	}
+	for _, st := range stages {
+		// Later exports win, so this overrides the mount path
+		exportedEnvVars = append(exportedEnvVars, fmt.Sprintf("%s=%s", "OUTPUT_"+strings.ToUpper(st.Name), st.Staging))
+	}
+	logger.Info("Exporting derived environment variables:")
+	for _, kv := range exportedEnvVars {
+		parts := strings.SplitN(kv, "=", 2)
+		logger.Info("  Exporting", "var", parts[0], "value", plan.displayValue(parts[0], parts[1]))
 	}
 
 	// --- Execute Command --- //
 	logger.Info("Executing command", "cmd", targetCmdArgs)
 	// Combine initial env with helper-exported vars
 	finalEnv := append(envVars, exportedEnvVars...)
-	executeCommand(logger, targetCmdPath, targetCmdArgs, finalEnv)
+	limits := runLimits{Timeout: settings.Timeout, Workspace: ws}
+	rio := reflexIO{Stdin: stdin.Reader, Stdout: reflexStdout(tio)}
+	var progressRecord io.Writer
+	if tio != nil {
+		progressRecord = tio.Progress
+	}
+	progressCh, err := startProgressRelay(logger, progressRecord)
+	if err != nil {
+		logger.Warn("Progress reporting is unavailable", "error", err)
+	} else {
+		rio.Progress = progressCh.Writer
+		finalEnv = append(finalEnv, progressCh.env()...)
+	}
+	var cacheTmp string
+	var cacheStdout *os.File
+	if cache != nil {
+		cache.updateStats(cacheStats{Misses: 1})
+		if cacheTmp, cacheStdout, err = cache.begin(runID); err != nil {
+			logger.Warn("Not storing this run in the result cache", "error", err)
+			cache = nil
+		} else {
+			tmp := cacheTmp
+			runGuard.onInterrupt(func() { os.RemoveAll(tmp) })
+			rio.Stdout = io.MultiWriter(rio.Stdout, cacheStdout)
+		}
+	}
+	// Pre hooks, the command and post hooks share the environment and
+	// workspace. An aborting pre hook skips the command; post hooks only run
+	// while the run is still successful.
+	hooks := &hookRunner{logger: logger, env: finalEnv, id: reflexID, workspace: ws, progress: rio.Progress}
+	exitCode, abort := hooks.run(plan.Hooks, hookPre)
+	var runErr *helperError
+	if !abort {
+		var code int
+		code, runErr = executeCommand(logger, plan.Command[0], plan.Command, finalEnv, reflexID, limits, rio)
+		if exitCode == 0 {
+			exitCode = code
+		}
+		if exitCode == 0 {
+			exitCode, _ = hooks.run(plan.Hooks, hookPost)
+		}
+	}
+	progressCh.finish(logger)
+	values, valueErrs := collectScalarOutputs(ws.OutputsFile, m.Outputs, exitCode == 0)
+	ws.cleanup(logger, exitCode != 0 && settings.KeepTmpOnFailure)
+	errs := hooks.Errors // Helper failures, reported once the run is wrapped up
+	if runErr != nil {
+		errs = append(errs, runErr)
+	}
+
+	// --- Validate Streamed Stdin and Scalar Outputs ($NHI_OUTPUTS) --- //
+	// A violation fails the run, so staged outputs are not published
+	if serr := stdin.err(); serr != nil {
+		valueErrs = append([]*helperError{serr}, valueErrs...)
+	}
+	stdin.close()
+	errs = append(errs, valueErrs...)
+	if exitCode == 0 && len(valueErrs) > 0 {
+		exitCode = valueErrs[0].Code
+	}
+
+	// --- Publish or Discard Staged Outputs --- //
+	// A signal received meanwhile is acted on once the mounts are consistent
+	runGuard.enterCritical()
+	if settings.StageOutputs {
+		if exitCode == 0 {
+			if verr := validateStagedOutputs(stages, m.OutputPaths); verr != nil {
+				errs = append(errs, verr)
+				exitCode = verr.Code
+			}
+		}
+		if exitCode == 0 {
+			if err = publishOutputs(logger, stages, runID); err != nil {
+				// The mounts are back to their previous content
+				errs = append(errs, internalError(err))
+				exitCode = exitInternal
+			}
+		}
+		if exitCode != 0 {
+			err = discardOutputs(logger, stages, runID, settings.KeepFailedOutputs)
+			if err != nil {
+				errs = append(errs, internalError(err))
+			}
+		}
+	}
+
+	runGuard.leaveCritical()
+
+	// --- Store the Result (successful runs only) --- //
+	if cache != nil {
+		cache.store(logger, cacheTmp, cacheStdout, key, outputMounts, values, exitCode == 0 && len(errs) == 0)
+	}
+
+	// --- Hand results back to the caller (runs even if the reflex failed) --- //
+	if tio == nil {
+		if err := chownOutputs(logger, outputMounts, owner); err != nil {
+			errs = append(errs, internalError(err))
+		}
+	}
+
+	// The reflex's own failure wins; otherwise a helper failure sets the code
+	if exitCode == 0 {
+		exitCode = exitCodeFor(errs)
+	}
+	report.ExitCode, report.Hooks, report.Outputs, report.Errors = exitCode, hooks.Results, values, errs
+	report.Duration = time.Since(report.StartedAt)
+	errs = append(errs, deliverResults(logger, report, tio, m.OutputPaths, owner)...)
+	writeErrors(os.Stderr, errs, errorFormat)
+	if exitCode == 0 {
+		exitCode = exitCodeFor(errs)
+	}
+	os.Exit(exitCode)
+}
+
+// runWithoutManifest executes the command when no manifest could be loaded.
+// The reflex still never runs as root, since nothing grants it permission to.
+func runWithoutManifest(logger *slog.Logger, owner *callingOwner, runID string, cmdArgs []string) {
+	if len(cmdArgs) == 0 {
+		fail(newError(categoryUsage, "", "Pass the reflex command after the image name; NHI_COMMAND needs a manifest declaring commands",
+			"No command provided to the entrypoint helper"))
+	}
+	if mode, _ := resolveIOMode(); mode == ioModeTar {
+		fail(newError(categoryUsage, "", "Tar I/O needs the manifest to know the inputs and outputs; use bind mounts instead",
+			"NHI_IO=tar requires a readable manifest at %s", manifestPath))
+	}
+	reflexID, err := resolveReflexIdentity(owner, false)
+	if err != nil {
+		fail(newError(categoryUsage, "", "Pass -e CALLING_UID=$(id -u) -e CALLING_GID=$(id -g), or run the container with --user", "%v", err))
+	}
+	env := append(os.Environ(), "NHI_RUN_ID="+runID) // Pass original env
+	env = append(env, reflexID.env()...)
+	exitCode, runErr := executeCommand(logger, cmdArgs[0], cmdArgs, env, reflexID, runLimits{}, reflexIO{Stdin: os.Stdin, Stdout: os.Stdout})
+	if runErr != nil {
+		writeErrors(os.Stderr, []*helperError{runErr}, errorFormat)
+	}
+	os.Exit(exitCode)
+}
+
+// receiveTarInputs sets up tar I/O from the tar on stdin, exiting on failure.
+// Outputs are created for the identity the reflex will run as; if it cannot
+// be resolved, the plan reports why.
+func receiveTarInputs(m manifesttypes.Manifest, owner *callingOwner) *tarIO {
+	limits, err := resolveTarLimits()
+	if err != nil {
+		fail(newError(categoryUsage, "", "Use a size such as 512M for NHI_TAR_MAX_SIZE and a positive count for NHI_TAR_MAX_ENTRIES", "%v", err))
+	}
+	id, _ := resolveReflexIdentity(owner, m.AllowsRoot())
+	tio, err := newTarIO(os.Stdin, m, limits, id)
+	if err != nil {
+		fail(newError(categoryContractViolation, "", "Stream a tar whose top-level entries are named after the manifest's inputs",
+			"Could not read inputs from the tar on stdin: %v", err))
+	}
+	return tio
+}
+
+// reflexStdout returns where the reflex's stdout goes: the helper's stdout,
+// or the capture file in tar mode, where stdout carries the output tar.
+func reflexStdout(tio *tarIO) io.Writer {
+	if tio != nil {
+		return tio.Stdout
+	}
+	return os.Stdout
 }
 
 // New function to handle showing the manifest
@@ -184,53 +517,20 @@ func showManifest() {
 	fmt.Print(string(manifestData))
 }
 
-func printUsage(m manifesttypes.Manifest, requiredEnvVars []string) {
-	fmt.Fprintln(os.Stderr, "Usage: <docker run options> <image> [-h|--help] <command> [args...]")
-	fmt.Fprintln(os.Stderr, "-----------------------------------------------------------------")
-	if m.Description != "" {
-		fmt.Fprintln(os.Stderr, "Description:")
-		for _, line := range strings.Split(m.Description, "\n") {
-			fmt.Fprintf(os.Stderr, "  %s\n", line)
-		}
-		fmt.Fprintln(os.Stderr, "")
-	}
-
-	// Print required env vars if provided (means we are exiting due to missing vars)
-	if len(requiredEnvVars) > 0 {
-		fmt.Fprintln(os.Stderr, "Required Environment Variables (must be set via -e or similar):")
-		for _, desc := range requiredEnvVars {
-			fmt.Fprintln(os.Stderr, desc)
-		}
-		fmt.Fprintln(os.Stderr, "")
-	}
-
-	// Print general environment variable info
-	fmt.Fprintln(os.Stderr, "Optional Environment Variables:")
-	fmt.Fprintln(os.Stderr, "  SHOW_MANIFEST=true: Print the raw manifest.yml content to stdout and exit.")
-	fmt.Fprintln(os.Stderr, "                      Example: docker run --rm -e SHOW_MANIFEST=true <image>")
-	fmt.Fprintln(os.Stderr, "  (Consult manifest.yml for other environment variables used by the reflex)")
-	fmt.Fprintln(os.Stderr, "")
+// printUsage prints the text help for the subcommand sub (empty for the
+// whole reflex) to stderr, listing missing required variables first.
+func printUsage(m manifesttypes.Manifest, sub string, missingEnv []string) {
+	_ = writeHelp(os.Stderr, m, sub, "text", missingEnv)
+}
 
-	// Print expected mount points based on manifest
-	if len(m.InputPaths) > 0 || len(m.OutputPaths) > 0 {
-		fmt.Fprintln(os.Stderr, "Expected Mount Points (must be provided via -v or similar):")
+// failNoCommand reports that there is nothing to execute, with the usage of
+// m, and exits.
+func failNoCommand(m manifesttypes.Manifest) {
+	if errorFormat == "text" {
+		printUsage(m, "", nil)
 	}
-	if len(m.InputPaths) > 0 {
-		fmt.Fprintln(os.Stderr, "  Inputs (mounted read-only):")
-		for name, spec := range m.InputPaths {
-			fmt.Fprintf(os.Stderr, "    -v /host/path/to/%s:/app/input_%s:ro  (%s)\n", name, name, spec.Description)
-		}
-	}
-	if len(m.OutputPaths) > 0 {
-		fmt.Fprintln(os.Stderr, "  Outputs (mounted read-write):")
-		for name, spec := range m.OutputPaths {
-			fmt.Fprintf(os.Stderr, "    -v /host/path/to/%s:/app/output_%s  (%s)\n", name, name, spec.Description)
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintln(os.Stderr, "Arguments:")
-	fmt.Fprintln(os.Stderr, "  <command> [args...] : The command and arguments the reflex should execute.")
+	fail(newError(categoryUsage, "", "Pass the reflex command after the image name, or set the image's CMD",
+		"No command provided to the entrypoint helper"))
 }
 
 // shellEscape wraps a string in single quotes, escaping any existing single quotes
@@ -239,13 +539,26 @@ func shellEscape(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
 }
 
+// reflexIO connects the reflex to its caller.
+type reflexIO struct {
+	Stdin    io.Reader // nil means no input
+	Stdout   io.Writer
+	Progress *os.File // Write end of the progress channel, passed as progressFD; nil means none
+}
+
 // executeCommand uses sh -c to ensure environment propagation.
-func executeCommand(logger *slog.Logger, cmdPath string, cmdArgs []string, envVars []string) {
+// When id is non-nil the shell (and therefore the reflex) is started with
+// that identity; the helper itself keeps its privileges and supervises the
+// run within limits. The reflex is connected to rio.
+// It returns the exit code the helper should exit with, along with the error
+// when the helper failed or stopped the reflex itself.
+func executeCommand(logger *slog.Logger, cmdPath string, cmdArgs []string, envVars []string, id *identity, limits runLimits, rio reflexIO) (int, *helperError) {
 	// Verify the target script exists (as the process user)
 	resolvedPath, err := exec.LookPath(cmdPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to find command '%s' in PATH: %v\n", cmdPath, err)
-		os.Exit(127)
+		e := newError(categoryCommandNotFound, cmdPath, "Check the image's ENTRYPOINT/CMD or the command passed after the image name",
+			"Failed to find command '%s' in PATH: %v", cmdPath, err)
+		return e.Code, e
 	}
 
 	// --- Build the shell command string ---
@@ -279,26 +592,23 @@ func executeCommand(logger *slog.Logger, cmdPath string, cmdArgs []string, envVa
 	// 3. Combine exports and command
 	fullCommand := exportString + commandString
 
-	// Log the command to be executed (consider DEBUG level if too verbose)
-	logger.Info("Executing via sh -c", "command", fullCommand)
+	// Only the exec part is logged: the exports may hold secrets and are
+	// logged, redacted, by the caller
+	logger.Debug("Executing via sh -c", "command", commandString)
 
 	// --- Execute the command string using sh ---
 	cmd := exec.Command("/bin/sh", "-c", fullCommand)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	cmd.Stdin = rio.Stdin
+	cmd.Stdout = rio.Stdout
+	if rio.Progress != nil {
+		cmd.ExtraFiles = []*os.File{rio.Progress} // Becomes progressFD
+	}
 	cmd.Stderr = os.Stderr
 	// cmd.Env is not needed as exports are part of the command string
-
-	err = cmd.Run()
-
-	// --- Handle exit code ---
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitError.ExitCode())
-		} else {
-			fmt.Fprintf(os.Stderr, "Error executing command '%s' via sh: %v\n", cmdPath, err)
-			os.Exit(1)
-		}
+	if cred := id.credential(); cred != nil {
+		logger.Info("Dropping privileges for reflex", "uid", cred.Uid, "gid", cred.Gid, "groups", cred.Groups)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
 	}
-	os.Exit(0)
-}
\ No newline at end of file
+
+	return superviseCommand(logger, cmd, limits)
+}
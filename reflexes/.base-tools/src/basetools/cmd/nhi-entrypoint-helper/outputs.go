@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Valid scalar output names, as for environment variables
+var outputNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// readScalarOutputs parses the key=value lines the reflex wrote to the file
+// exported as NHI_OUTPUTS. Blank lines are ignored and a later line for the
+// same key wins. A missing file holds no outputs.
+func readScalarOutputs(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || !outputNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected key=value", line)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// collectScalarOutputs reads the scalar outputs of a run and converts them to
+// JSON values. When validate is set (the run succeeded), every value must be
+// declared and match its type, and required outputs must be present; after a
+// failed run the values are reported as written, without checks.
+func collectScalarOutputs(path string, specs map[string]manifesttypes.InputSpec, validate bool) (map[string]interface{}, []*helperError) {
+	raw, err := readScalarOutputs(path)
+	if err != nil {
+		return map[string]interface{}{}, []*helperError{newError(categoryContractViolation, "NHI_OUTPUTS", "Write one key=value line per output",
+			"Could not parse scalar outputs: %v", err)}
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	var errs []*helperError
+	for _, name := range manifesttypes.SortedKeys(raw) {
+		spec, declared := specs[name]
+		switch {
+		case !validate:
+			values[name] = spec.TypedValue(raw[name])
+		case !declared:
+			errs = append(errs, newError(categoryContractViolation, name, "Declare it under outputs: in manifest.yml",
+				"Scalar output '%s' is not declared in the manifest", name))
+		default:
+			if err := spec.ValidateValue(raw[name]); err != nil {
+				errs = append(errs, newError(categoryContractViolation, name, fmt.Sprintf("Write a value of type %s for %s", typeOrString(spec.Type), name),
+					"Invalid value for scalar output '%s': %v", name, err))
+				continue
+			}
+			values[name] = spec.TypedValue(raw[name])
+		}
+	}
+	if validate {
+		for _, name := range manifesttypes.SortedKeys(specs) {
+			if _, ok := raw[name]; !ok && specs[name].Required {
+				errs = append(errs, newError(categoryContractViolation, name, fmt.Sprintf("The reflex must write %s=... to $NHI_OUTPUTS", name),
+					"Required scalar output '%s' was not written", name))
+			}
+		}
+	}
+	return values, errs
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// AT_SYMLINK_NOFOLLOW in the flags of fchownat(2)
+const atSymlinkNoFollow = 0x100
+
+// callingOwner holds the UID/GID that output files are handed back to.
+type callingOwner struct {
+	UID int
+	GID int
+}
+
+// resolveCallingOwner reads CALLING_UID/CALLING_GID from the environment.
+// It returns nil when neither is set, and an error when only one is set or
+// either is not a non-negative integer.
+func resolveCallingOwner() (*callingOwner, error) {
+	uidStr := os.Getenv(manifesttypes.CallingUIDEnv)
+	gidStr := os.Getenv(manifesttypes.CallingGIDEnv)
+	if uidStr == "" && gidStr == "" {
+		return nil, nil
+	}
+	if uidStr == "" || gidStr == "" {
+		return nil, fmt.Errorf("%s and %s must be set together", manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv)
+	}
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil || uid < 0 {
+		return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", manifesttypes.CallingUIDEnv, uidStr)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil || gid < 0 {
+		return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", manifesttypes.CallingGIDEnv, gidStr)
+	}
+	return &callingOwner{UID: uid, GID: gid}, nil
+}
+
+// chownOutputs recursively hands the contents of every output mount to the
+// calling user. The mount points themselves belong to the host and are left
+// alone. Entries are re-owned by name relative to an open directory and
+// symlinks are never followed, so a reflex cannot point a link outside its
+// mount and have the helper chown the target, even by swapping a directory
+// for a link while the walk runs.
+func chownOutputs(logger *slog.Logger, outputs map[string]string, owner *callingOwner) error {
+	if owner == nil || len(outputs) == 0 {
+		return nil
+	}
+	if euid := os.Geteuid(); euid != 0 {
+		if euid != owner.UID {
+			logger.Warn("Not running as root; cannot hand outputs to calling user", "euid", euid, "uid", owner.UID, "gid", owner.GID)
+		}
+		return nil
+	}
+
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		root := outputs[name]
+		logger.Info("Fixing output ownership", "path", root, "uid", owner.UID, "gid", owner.GID)
+		fd, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("fixing ownership of output '%s': opening %s: %w", name, root, err)
+		}
+		dir := os.NewFile(uintptr(fd), root)
+		err = chownTree(dir, owner)
+		dir.Close()
+		if err != nil {
+			return fmt.Errorf("fixing ownership of output '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// chownTree hands every entry below the open directory dir to owner. Each
+// entry is changed with fchownat(AT_SYMLINK_NOFOLLOW) and directories are
+// descended into with openat(O_NOFOLLOW), so the walk never resolves a path
+// the reflex could have redirected. Entries removed or replaced by something
+// other than a directory while the walk runs are skipped.
+func chownTree(dir *os.File, owner *callingOwner) error {
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", dir.Name(), err)
+	}
+	sort.Strings(names)
+	fd := int(dir.Fd())
+	for _, name := range names {
+		path := filepath.Join(dir.Name(), name)
+		if err := syscall.Fchownat(fd, name, owner.UID, owner.GID, atSymlinkNoFollow); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				continue
+			}
+			return fmt.Errorf("chown %s: %w", path, err)
+		}
+		sub, err := syscall.Openat(fd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		switch {
+		case errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.ELOOP), errors.Is(err, syscall.ENOENT):
+			continue // Not a directory
+		case err != nil:
+			return fmt.Errorf("opening %s: %w", path, err)
+		}
+		subdir := os.NewFile(uintptr(sub), path)
+		err = chownTree(subdir, owner)
+		subdir.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
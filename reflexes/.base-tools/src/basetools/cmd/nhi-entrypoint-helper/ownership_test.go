@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestChownOutputs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("handing outputs over needs root")
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	owner := &callingOwner{UID: 65534, GID: 65534}
+
+	outside := t.TempDir() // Must keep its owner
+	writeTree(t, outside, map[string]string{"secret": "s"})
+	mount := filepath.Join(t.TempDir(), "output")
+	writeTree(t, mount, map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/deep/c.txt": "c"})
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(mount, "file-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(mount, "dir", "dir-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chownOutputs(logger, map[string]string{"out": mount}, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantUID int
+	}{
+		{path: mount, wantUID: 0}, // The mount point belongs to the host
+		{path: filepath.Join(mount, "a.txt"), wantUID: owner.UID},
+		{path: filepath.Join(mount, "dir"), wantUID: owner.UID},
+		{path: filepath.Join(mount, "dir", "deep", "c.txt"), wantUID: owner.UID},
+		{path: filepath.Join(mount, "file-link"), wantUID: owner.UID},
+		{path: filepath.Join(mount, "dir", "dir-link"), wantUID: owner.UID},
+		{path: outside, wantUID: 0},
+		{path: filepath.Join(outside, "secret"), wantUID: 0},
+	}
+	for _, tt := range tests {
+		info, err := os.Lstat(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid := int(info.Sys().(*syscall.Stat_t).Uid); uid != tt.wantUID {
+			t.Errorf("%s is owned by %d, want %d", tt.path, uid, tt.wantUID)
+		}
+	}
+}
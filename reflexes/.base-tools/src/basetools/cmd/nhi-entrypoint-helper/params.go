@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variable pointing at a parameter file, an alternative to one -e flag per
+// manifest environment variable
+const paramsFileEnv = "NHI_PARAMS_FILE"
+
+// loadParams reads the parameter file at path: a JSON or YAML object mapping
+// names of the manifest's environment variables to strings, numbers or
+// booleans. Values are returned as written, which is what the reflex sees in
+// its environment: 1.0 stays "1.0".
+func loadParams(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]yaml.Node // JSON is valid YAML
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON or YAML object: %w", err)
+	}
+	params := make(map[string]string, len(raw))
+	for name, node := range raw {
+		if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+			return nil, fmt.Errorf("parameter %s: expected a string, number or boolean", name)
+		}
+		params[name] = node.Value
+	}
+	return params, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nhi/basetools/pkg/examples"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestLoadParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "json", content: `{"COUNT": 3, "RATIO": 1.0, "NAME": "a b", "ON": true}`, want: map[string]string{"COUNT": "3", "RATIO": "1.0", "NAME": "a b", "ON": "true"}},
+		{name: "yaml", content: "COUNT: 3\nNAME: site\n", want: map[string]string{"COUNT": "3", "NAME": "site"}},
+		{name: "null value", content: `{"NAME": null}`, wantErr: true},
+		{name: "nested value", content: `{"NAME": {"a": 1}}`, wantErr: true},
+		{name: "not an object", content: `[1, 2]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "params")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadParams(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("loadParams() = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("%s = %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+}
+
+// Explicit environment values win over the parameter file, which wins over
+// manifest defaults.
+func TestPlanParameterPrecedence(t *testing.T) {
+	t.Setenv(examples.IOBaseEnv, t.TempDir())
+	m := manifesttypes.Manifest{Environment: map[string]manifesttypes.InputSpec{
+		"NHI_TEST_ALL":     {Type: "string", Default: "default"},
+		"NHI_TEST_FILE":    {Type: "string", Default: "default"},
+		"NHI_TEST_DEFAULT": {Type: "string", Default: "default"},
+		"NHI_TEST_EMPTY":   {Type: "string", Default: "default"},
+		"NHI_TEST_COUNT":   {Type: "integer"},
+		"NHI_TEST_NONE":    {Type: "string"},
+	}}
+	params := filepath.Join(t.TempDir(), "params.yml")
+	content := "NHI_TEST_ALL: file\nNHI_TEST_FILE: file\nNHI_TEST_EMPTY: file\nNHI_TEST_COUNT: many\n"
+	if err := os.WriteFile(params, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(paramsFileEnv, params)
+	t.Setenv("NHI_TEST_ALL", "env")
+	t.Setenv("NHI_TEST_EMPTY", "") // An empty value counts as unset
+
+	p := buildPlan(m, []string{"true"}, nil, "run")
+	tests := []struct {
+		name       string
+		wantValue  string
+		wantSource string // Empty when the variable is not exported
+	}{
+		{name: "NHI_TEST_ALL", wantValue: "env", wantSource: sourceEnvironment},
+		{name: "NHI_TEST_FILE", wantValue: "file", wantSource: sourceParamsFile},
+		{name: "NHI_TEST_DEFAULT", wantValue: "default", wantSource: sourceDefault},
+		{name: "NHI_TEST_EMPTY", wantValue: "file", wantSource: sourceParamsFile},
+		{name: "NHI_TEST_COUNT", wantValue: "many", wantSource: sourceParamsFile},
+		{name: "NHI_TEST_NONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *envPlan
+			for i := range p.Environment {
+				if p.Environment[i].Name == tt.name {
+					got = &p.Environment[i]
+				}
+			}
+			switch {
+			case tt.wantSource == "" && got != nil:
+				t.Fatalf("exported %+v, want nothing", *got)
+			case tt.wantSource == "":
+			case got == nil:
+				t.Fatal("not exported")
+			case got.Value != tt.wantValue || got.Source != tt.wantSource:
+				t.Fatalf("got %s from %s, want %s from %s", got.Value, got.Source, tt.wantValue, tt.wantSource)
+			}
+		})
+	}
+
+	// The invalid file value is reported against the file, as a warning
+	// without runtime.strict_environment
+	var found bool
+	for _, w := range p.Warnings {
+		if strings.Contains(w, "NHI_TEST_COUNT") && strings.Contains(w, paramsFileEnv) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no warning for the invalid NHI_TEST_COUNT in the parameter file: %v", p.Warnings)
+	}
+}
+
+// Values that break their type or pattern are rejected only with
+// runtime.strict_environment (or NHI_STRICT_ENV).
+func TestPlanStrictEnvironment(t *testing.T) {
+	t.Setenv(examples.IOBaseEnv, t.TempDir())
+	tests := []struct {
+		name        string
+		value       string
+		strict      bool
+		strictEnv   string
+		wantError   bool
+		wantWarning bool
+	}{
+		{name: "matching value", value: "png", strict: true},
+		{name: "non-matching value is a warning", value: "gif", wantWarning: true},
+		{name: "non-matching value with strict_environment", value: "gif", strict: true, wantError: true},
+		{name: "non-matching value with NHI_STRICT_ENV", value: "gif", strictEnv: "1", wantError: true},
+		{name: "NHI_STRICT_ENV overrides the manifest", value: "gif", strict: true, strictEnv: "0", wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := manifesttypes.Manifest{
+				Environment: map[string]manifesttypes.InputSpec{"NHI_TEST_FORMAT": {Type: "string", Pattern: "[jp][pn]g"}},
+				Runtime:     &manifesttypes.RuntimeSpec{StrictEnvironment: tt.strict},
+			}
+			t.Setenv("NHI_TEST_FORMAT", tt.value)
+			t.Setenv("NHI_STRICT_ENV", tt.strictEnv)
+
+			p := buildPlan(m, []string{"true"}, nil, "run")
+			var gotError, gotWarning bool
+			for _, e := range p.Errors {
+				gotError = gotError || (strings.Contains(e.Error(), "NHI_TEST_FORMAT") && e.Category == categoryContractViolation)
+			}
+			for _, w := range p.Warnings {
+				gotWarning = gotWarning || strings.Contains(w, "NHI_TEST_FORMAT")
+			}
+			if gotError != tt.wantError || gotWarning != tt.wantWarning {
+				t.Fatalf("error = %v, warning = %v, want %v, %v (errors %v, warnings %v)", gotError, gotWarning, tt.wantError, tt.wantWarning, p.Errors, p.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"nhi/basetools/pkg/manifesttypes"
+	"nhi/basetools/pkg/requirements"
+)
+
+// Placeholder shown instead of secret values
+const redacted = "<redacted>"
+
+// Variable names treated as secret even when the manifest does not say so
+var secretNamePattern = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSW(OR)?D|API_?KEY|PRIVATE_?KEY|CREDENTIAL)`)
+
+// Sources of an exported environment value
+const (
+	sourceEnvironment = "environment" // Set by the caller
+	sourceParamsFile  = "params_file" // Read from NHI_PARAMS_FILE
+	sourceDefault     = "default"     // Manifest default applied by the helper
+	sourceHelper      = "helper"      // Derived by the helper (mounts, run id, identity)
+)
+
+// envPlan is one variable the reflex will see.
+type envPlan struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// mountPlan describes one input or output mount.
+type mountPlan struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	EnvVar   string `json:"env_var"`
+	Required bool   `json:"required"`
+	Found    bool   `json:"found"`
+	Staging  string `json:"staging,omitempty"`
+}
+
+// runAsPlan describes the identity the reflex will run as.
+type runAsPlan struct {
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups,omitempty"`
+	User   string   `json:"user,omitempty"`
+}
+
+// settingsPlan mirrors runSettings for display.
+type settingsPlan struct {
+	StageOutputs      bool   `json:"stage_outputs"`
+	KeepFailedOutputs bool   `json:"keep_failed_outputs"`
+	Timeout           string `json:"timeout,omitempty"`
+	TmpMaxBytes       int64  `json:"tmpdir_max_bytes,omitempty"`
+	KeepTmpOnFailure  bool   `json:"keep_tmpdir_on_failure"`
+	StrictEnvironment bool   `json:"strict_environment"`
+	CacheDir          string `json:"cache_dir,omitempty"`
+	IOMode            string `json:"io_mode"`
+}
+
+// invocationPlan is everything the helper has resolved about a run before
+// executing it. It is built without side effects, so it can be printed by
+// --dry-run as well as executed.
+type invocationPlan struct {
+	RunID           string         `json:"run_id"`
+	Subcommand      string         `json:"subcommand,omitempty"` // Selected subcommand of a multi-command reflex
+	Command         []string       `json:"command"`
+	Environment     []envPlan      `json:"environment"`
+	Inputs          []mountPlan    `json:"inputs"`
+	Outputs         []mountPlan    `json:"outputs"`
+	DefaultsApplied []string       `json:"defaults_applied,omitempty"`
+	RunAs           *runAsPlan     `json:"run_as,omitempty"`
+	Settings        settingsPlan   `json:"settings"`
+	Hooks           []hookPlan     `json:"hooks,omitempty"`
+	Warnings        []string       `json:"warnings,omitempty"`
+	Errors          []*helperError `json:"errors,omitempty"`
+
+	// Resolved values used for execution; never printed
+	manifest   manifesttypes.Manifest
+	identity   *identity
+	settings   runSettings
+	missingEnv []string // Usage lines for missing required variables
+}
+
+// buildPlan validates the manifest contract against the current environment
+// and mounts and resolves the invocation. Problems are collected in Errors
+// rather than returned, so that a dry run can report all of them at once.
+func buildPlan(m manifesttypes.Manifest, cmdArgs []string, owner *callingOwner, runID string) *invocationPlan {
+	p := &invocationPlan{manifest: m, RunID: runID, Command: append([]string(nil), cmdArgs...)}
+
+	// --- Command --- //
+	if resolved, err := exec.LookPath(cmdArgs[0]); err != nil {
+		p.Errors = append(p.Errors, newError(categoryCommandNotFound, cmdArgs[0], "Check the image's ENTRYPOINT/CMD or the command passed after the image name",
+			"Failed to find command '%s' in PATH: %v", cmdArgs[0], err))
+	} else {
+		p.Command[0] = resolved
+	}
+
+	// --- Requirements --- //
+	// Reported as one error so the image can be fixed in one go
+	if problems := requirements.Check(m.Requires); len(problems) > 0 {
+		msgs := make([]string, len(problems))
+		for i, pr := range problems {
+			msgs[i] = pr.Error()
+		}
+		p.Errors = append(p.Errors, newError(categoryMissingRequirement, "", "Build the image on a base that provides them, e.g. the matching 100hellos image",
+			"Missing prerequisites: %s", strings.Join(msgs, "; ")))
+	}
+
+	// --- Identity and settings --- //
+	var err error
+	if p.identity, err = resolveReflexIdentity(owner, m.AllowsRoot()); err != nil {
+		p.Errors = append(p.Errors, newError(categoryUsage, "", "Pass -e CALLING_UID=$(id -u) -e CALLING_GID=$(id -g), or run the container with --user", "%v", err))
+	} else if p.identity != nil {
+		p.RunAs = &runAsPlan{UID: p.identity.UID, GID: p.identity.GID, Groups: p.identity.Groups, User: p.identity.Name}
+	}
+	if p.settings, err = resolveRunSettings(m.Runtime); err != nil {
+		p.Errors = append(p.Errors, newError(categoryUsage, "", "Fix the NHI_* variable or the manifest's runtime section", "%v", err))
+	}
+	p.Settings = settingsPlan{
+		StageOutputs:      p.settings.StageOutputs,
+		KeepFailedOutputs: p.settings.KeepFailedOutputs,
+		TmpMaxBytes:       p.settings.TmpMaxBytes,
+		KeepTmpOnFailure:  p.settings.KeepTmpOnFailure,
+		StrictEnvironment: p.settings.StrictEnvironment,
+		CacheDir:          p.settings.CacheDir,
+		IOMode:            p.settings.IOMode,
+	}
+	if p.settings.Timeout > 0 {
+		p.Settings.Timeout = p.settings.Timeout.String()
+	}
+
+	// --- Hooks --- //
+	hooks, errs := planHooks(m.Hooks, p.settings.Timeout)
+	p.Hooks = hooks
+	p.Errors = append(p.Errors, errs...)
+
+	// --- Inputs --- //
+	for _, name := range manifesttypes.SortedKeys(m.InputPaths) {
+		spec := m.InputPaths[name]
+		mp := mountPlan{
+			Name:     name,
+			Path:     filepath.Join(ioBasePath(), "input_"+name),
+			EnvVar:   "INPUT_" + strings.ToUpper(name),
+			Required: spec.Required,
+			Staging:  spec.Staging,
+		}
+		_, err := os.Stat(mp.Path)
+		mp.Found = err == nil
+		switch {
+		case err == nil:
+		case os.IsNotExist(err) && spec.Required:
+			hint := fmt.Sprintf("Mount it with -v /host/path/to/%s:%s:ro", name, mp.Path)
+			if p.settings.IOMode == ioModeTar {
+				hint = fmt.Sprintf("Add a top-level '%s' entry to the tar on stdin", name)
+			}
+			p.Errors = append(p.Errors, newError(categoryMissingInput, name, hint,
+				"Required input '%s' not found at expected path: %s", name, mp.Path))
+		case os.IsNotExist(err):
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Optional input '%s' not mounted at %s; %s will not be set", name, mp.Path, mp.EnvVar))
+		default:
+			p.Errors = append(p.Errors, newError(categoryMissingInput, name, "", "Checking input path %s for '%s': %v", mp.Path, name, err))
+		}
+		p.Inputs = append(p.Inputs, mp)
+	}
+
+	// --- Outputs --- //
+	for _, name := range manifesttypes.SortedKeys(m.OutputPaths) {
+		spec := m.OutputPaths[name]
+		mp := mountPlan{
+			Name:     name,
+			Path:     filepath.Join(ioBasePath(), "output_"+name),
+			EnvVar:   "OUTPUT_" + strings.ToUpper(name),
+			Required: spec.Required,
+		}
+		if p.settings.StageOutputs {
+			mp.Staging = "staged"
+		}
+		info, err := os.Stat(mp.Path)
+		mp.Found = err == nil
+		switch {
+		case err == nil && !info.IsDir():
+			p.Errors = append(p.Errors, newError(categoryUnwritableOutput, name, "Mount a host directory, not a file",
+				"Output path '%s' (%s) is not a directory.", name, mp.Path))
+		case err == nil:
+			p.checkOutputWritable(name, mp.Path, info)
+		case os.IsNotExist(err) && !spec.Required && os.Geteuid() == 0:
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Optional output '%s' not mounted; %s will be created and discarded with the container", name, mp.Path))
+		case os.IsNotExist(err):
+			p.Errors = append(p.Errors, newError(categoryUnwritableOutput, name, fmt.Sprintf("Mount it with -v /host/path/to/%s:%s", name, mp.Path),
+				"Required output directory '%s' not found at expected path: %s", name, mp.Path))
+		default:
+			p.Errors = append(p.Errors, newError(categoryUnwritableOutput, name, "", "Checking output path %s for '%s': %v", mp.Path, name, err))
+		}
+		p.Outputs = append(p.Outputs, mp)
+	}
+
+	// --- Environment --- //
+	// Explicit environment values win over the parameter file, which wins
+	// over manifest defaults
+	params := p.loadParams(m)
+	for _, name := range manifesttypes.SortedKeys(m.Environment) {
+		spec := m.Environment[name]
+		secret := spec.Secret || secretNamePattern.MatchString(name)
+		if val, ok := os.LookupEnv(name); ok && val != "" {
+			if err := spec.ValidateValue(val); err != nil {
+				p.invalidValue(name, fmt.Sprintf("Pass a %s value with -e %s=...", typeOrString(spec.Type), name),
+					fmt.Sprintf("Invalid value for environment variable %s: %v", name, err))
+			}
+			p.Environment = append(p.Environment, envPlan{Name: name, Value: val, Source: sourceEnvironment, Secret: secret})
+			continue
+		}
+		if val, ok := params[name]; ok {
+			if err := spec.ValidateValue(val); err != nil {
+				p.invalidValue(name, fmt.Sprintf("Give %s a %s value in %s", name, typeOrString(spec.Type), os.Getenv(paramsFileEnv)),
+					fmt.Sprintf("Invalid value for parameter %s in %s: %v", name, paramsFileEnv, err))
+			}
+			p.Environment = append(p.Environment, envPlan{Name: name, Value: val, Source: sourceParamsFile, Secret: secret})
+			continue
+		}
+		if spec.Default != "" {
+			p.Environment = append(p.Environment, envPlan{Name: name, Value: spec.Default, Source: sourceDefault, Secret: secret})
+			p.DefaultsApplied = append(p.DefaultsApplied, name)
+			continue
+		}
+		if spec.Required {
+			p.Errors = append(p.Errors, newError(categoryMissingEnv, name, fmt.Sprintf("Set it with -e %s=... or in %s", name, paramsFileEnv),
+				"Missing required environment variable %s", name))
+			p.missingEnv = append(p.missingEnv, fmt.Sprintf("  - %s: %s", name, spec.Description))
+		}
+	}
+	for _, mp := range p.Inputs {
+		if mp.Found {
+			p.Environment = append(p.Environment, envPlan{Name: mp.EnvVar, Value: mp.Path, Source: sourceHelper})
+		}
+	}
+	for _, mp := range p.Outputs {
+		p.Environment = append(p.Environment, envPlan{Name: mp.EnvVar, Value: mp.Path, Source: sourceHelper})
+	}
+	p.Environment = append(p.Environment, envPlan{Name: "NHI_RUN_ID", Value: p.RunID, Source: sourceHelper})
+	for _, kv := range p.identity.env() {
+		parts := strings.SplitN(kv, "=", 2)
+		p.Environment = append(p.Environment, envPlan{Name: parts[0], Value: parts[1], Source: sourceHelper})
+	}
+	return p
+}
+
+// loadParams reads the parameter file named by NHI_PARAMS_FILE, if any.
+// Problems with the file are recorded as plan errors; names the manifest
+// does not declare are ignored with a warning, since one file may serve
+// several reflexes or subcommands.
+func (p *invocationPlan) loadParams(m manifesttypes.Manifest) map[string]string {
+	path := os.Getenv(paramsFileEnv)
+	if path == "" {
+		return nil
+	}
+	params, err := loadParams(path)
+	switch {
+	case os.IsNotExist(err):
+		p.Errors = append(p.Errors, newError(categoryMissingInput, paramsFileEnv, fmt.Sprintf("Mount the file, e.g. -v /host/path/to/params.yml:%s:ro", path),
+			"Parameter file not found: %s", path))
+		return nil
+	case err != nil:
+		p.Errors = append(p.Errors, newError(categoryContractViolation, paramsFileEnv, "Write an object mapping variable names to strings, numbers or booleans",
+			"Invalid parameter file %s: %v", path, err))
+		return nil
+	}
+	for _, name := range manifesttypes.SortedKeys(params) {
+		if _, ok := m.Environment[name]; !ok {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Parameter '%s' in %s is not declared in the manifest; ignoring it", name, paramsFileEnv))
+		}
+	}
+	return params
+}
+
+// invalidValue records a value that breaks its declared type or pattern. It
+// is a contract violation with runtime.strict_environment and only a warning
+// otherwise, so existing callers keep working while the manifest tightens.
+func (p *invocationPlan) invalidValue(name, hint, msg string) {
+	if p.settings.StrictEnvironment {
+		p.Errors = append(p.Errors, newError(categoryContractViolation, name, hint, "%s", msg))
+		return
+	}
+	p.Warnings = append(p.Warnings, msg+"; set runtime.strict_environment to reject it")
+}
+
+// parameterSources returns, for every declared environment variable that
+// has a value, where the value came from.
+func (p *invocationPlan) parameterSources() map[string]string {
+	sources := make(map[string]string)
+	for _, e := range p.Environment {
+		if _, ok := p.manifest.Environment[e.Name]; ok {
+			sources[e.Name] = e.Source
+		}
+	}
+	return sources
+}
+
+// checkOutputWritable verifies, without writing anything, that an output
+// mount is writable by the helper and by the identity the reflex will run as.
+func (p *invocationPlan) checkOutputWritable(name, path string, info os.FileInfo) {
+	if err := syscall.Access(path, 0x2 /* W_OK */); err != nil {
+		p.Errors = append(p.Errors, newError(categoryUnwritableOutput, name, "Make the host directory writable by the container user, or mount it without :ro",
+			"Output directory '%s' (%s) is not writable by current user (UID: %d, GID: %d): %v", name, path, os.Geteuid(), os.Getegid(), err))
+		return
+	}
+	if p.identity != nil && !writableBy(info, p.identity) {
+		p.Errors = append(p.Errors, newError(categoryUnwritableOutput, name, "Make the host directory writable by CALLING_UID/CALLING_GID",
+			"Output directory '%s' (%s) is not writable by reflex user (UID: %d, GID: %d)", name, path, p.identity.UID, p.identity.GID))
+	}
+}
+
+// exports returns the KEY=VALUE pairs the helper adds to the environment it
+// passes through from the caller.
+func (p *invocationPlan) exports() []string {
+	vars := make([]string, 0, len(p.Environment))
+	for _, e := range p.Environment {
+		if e.Source != sourceEnvironment {
+			vars = append(vars, e.Name+"="+e.Value)
+		}
+	}
+	return vars
+}
+
+// displayValue returns value, or a placeholder when name holds a secret.
+func (p *invocationPlan) displayValue(name, value string) string {
+	if p.manifest.Environment[name].Secret || secretNamePattern.MatchString(name) {
+		return redacted
+	}
+	return value
+}
+
+// redactedCopy returns the plan with secret values masked, for display.
+func (p *invocationPlan) redactedCopy() invocationPlan {
+	out := *p
+	out.Environment = make([]envPlan, len(p.Environment))
+	for i, e := range p.Environment {
+		if e.Secret {
+			e.Value = redacted
+		}
+		out.Environment[i] = e
+	}
+	return out
+}
+
+// writePlan renders the plan as "json" or "text".
+func writePlan(w io.Writer, p *invocationPlan, format string) error {
+	shown := p.redactedCopy()
+	switch strings.ToLower(format) {
+	case "json":
+		data, err := json.MarshalIndent(shown, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal plan: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "", "text":
+		writePlanText(w, &shown)
+		return nil
+	default:
+		return fmt.Errorf("unsupported plan format: %s", format)
+	}
+}
+
+func writePlanText(w io.Writer, p *invocationPlan) {
+	fmt.Fprintf(w, "Invocation plan (dry run, run id %s)\n", p.RunID)
+	fmt.Fprintln(w, "-----------------------------------------------------------------")
+	if p.Subcommand != "" {
+		fmt.Fprintf(w, "Subcommand: %s\n", p.Subcommand)
+	}
+	fmt.Fprintf(w, "Command: %s\n", strings.Join(p.Command, " "))
+	if p.RunAs != nil {
+		fmt.Fprintf(w, "Run as:  uid=%d gid=%d groups=%v %s\n", p.RunAs.UID, p.RunAs.GID, p.RunAs.Groups, p.RunAs.User)
+	} else {
+		fmt.Fprintf(w, "Run as:  current user (uid=%d gid=%d)\n", os.Geteuid(), os.Getegid())
+	}
+
+	fmt.Fprintln(w, "\nEnvironment (declared in the manifest or exported by the helper):")
+	for _, e := range p.Environment {
+		fmt.Fprintf(w, "  %s=%s  [%s]\n", e.Name, e.Value, e.Source)
+	}
+	if len(p.DefaultsApplied) > 0 {
+		fmt.Fprintf(w, "\nDefaults applied: %s\n", strings.Join(p.DefaultsApplied, ", "))
+	}
+
+	writeMounts := func(title string, mounts []mountPlan) {
+		if len(mounts) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s:\n", title)
+		for _, mp := range mounts {
+			state := "found"
+			if !mp.Found {
+				state = "missing"
+			}
+			req := "optional"
+			if mp.Required {
+				req = "required"
+			}
+			line := fmt.Sprintf("  %s: %s (%s, %s)", mp.Name, mp.Path, state, req)
+			if mp.Staging != "" {
+				line += " staging=" + mp.Staging
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	writeMounts("Inputs", p.Inputs)
+	writeMounts("Outputs", p.Outputs)
+
+	fmt.Fprintln(w, "\nSettings:")
+	fmt.Fprintf(w, "  io=%s stage_outputs=%t keep_failed_outputs=%t keep_tmpdir_on_failure=%t strict_environment=%t\n",
+		p.Settings.IOMode, p.Settings.StageOutputs, p.Settings.KeepFailedOutputs, p.Settings.KeepTmpOnFailure, p.Settings.StrictEnvironment)
+	if p.Settings.Timeout != "" {
+		fmt.Fprintf(w, "  timeout=%s\n", p.Settings.Timeout)
+	}
+	if p.Settings.TmpMaxBytes > 0 {
+		fmt.Fprintf(w, "  tmpdir_max_bytes=%d\n", p.Settings.TmpMaxBytes)
+	}
+	if p.Settings.CacheDir != "" {
+		fmt.Fprintf(w, "  cache_dir=%s\n", p.Settings.CacheDir)
+	}
+
+	if len(p.Hooks) > 0 {
+		fmt.Fprintln(w, "\nHooks:")
+		for _, h := range p.Hooks {
+			line := fmt.Sprintf("  %s %s: %s (on_failure=%s", h.Phase, h.Name, strings.Join(h.Command, " "), h.OnFailure)
+			if h.Timeout != "" {
+				line += " timeout=" + h.Timeout
+			}
+			fmt.Fprintln(w, line+")")
+		}
+	}
+
+	if len(p.Warnings) > 0 {
+		fmt.Fprintln(w, "\nWarnings:")
+		for _, msg := range p.Warnings {
+			fmt.Fprintf(w, "  - %s\n", msg)
+		}
+	}
+	if len(p.Errors) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		for _, e := range p.Errors {
+			fmt.Fprintf(w, "  - [%s] %s\n", e.Category, e.Message)
+			if e.Hint != "" {
+				fmt.Fprintf(w, "    Hint: %s\n", e.Hint)
+			}
+		}
+	}
+}
+
+// typeOrString returns the declared type, defaulting to "string".
+func typeOrString(t string) string {
+	if t == "" {
+		return "string"
+	}
+	return t
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Unprivileged user provided by the 100hellos base images
+const reflexUserName = "nhi"
+
+// identity is the user the reflex process is executed as after the helper
+// has finished its privileged preparation.
+type identity struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+	Name   string // Empty when the UID has no passwd entry
+	Home   string
+}
+
+// resolveReflexIdentity decides who the reflex runs as. It returns nil when
+// no privilege drop is needed, either because the helper is not root or
+// because the manifest allows the reflex to keep root.
+//
+// When the helper is root, CALLING_UID/CALLING_GID take precedence so that
+// outputs are written as the caller directly; otherwise the reflex runs as
+// the nhi user.
+func resolveReflexIdentity(owner *callingOwner, allowRoot bool) (*identity, error) {
+	if os.Geteuid() != 0 {
+		return nil, nil
+	}
+	if allowRoot {
+		return nil, nil
+	}
+
+	if owner != nil {
+		if owner.UID == 0 {
+			return nil, fmt.Errorf("refusing to run reflex as root (%s=0); set runtime.allow_root: true in manifest.yml to permit this", manifesttypes.CallingUIDEnv)
+		}
+		id := &identity{UID: uint32(owner.UID), GID: uint32(owner.GID), Groups: []uint32{uint32(owner.GID)}}
+		if u, err := user.LookupId(strconv.Itoa(owner.UID)); err == nil {
+			id.Name = u.Username
+			id.Home = u.HomeDir
+		}
+		return id, nil
+	}
+
+	u, err := user.Lookup(reflexUserName)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to run reflex as root: no '%s' user and no %s/%s to drop to; set runtime.allow_root: true in manifest.yml to permit this: %w",
+			reflexUserName, manifesttypes.CallingUIDEnv, manifesttypes.CallingGIDEnv, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for user '%s': %w", u.Uid, reflexUserName, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for user '%s': %w", u.Gid, reflexUserName, err)
+	}
+	id := &identity{UID: uint32(uid), GID: uint32(gid), Name: u.Username, Home: u.HomeDir}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		groupIDs = []string{u.Gid}
+	}
+	for _, g := range groupIDs {
+		if n, err := strconv.ParseUint(g, 10, 32); err == nil {
+			id.Groups = append(id.Groups, uint32(n))
+		}
+	}
+	return id, nil
+}
+
+// credential returns the credential applied to the reflex process. The Go
+// runtime calls setgroups, setgid and setuid (in that order) in the child
+// between fork and exec, so the drop is permanent for the reflex while the
+// helper keeps root for post-run work such as handing outputs to the caller.
+func (id *identity) credential() *syscall.Credential {
+	if id == nil {
+		return nil
+	}
+	return &syscall.Credential{Uid: id.UID, Gid: id.GID, Groups: id.Groups}
+}
+
+// env returns the variables that describe the identity to the reflex.
+func (id *identity) env() []string {
+	if id == nil || id.Name == "" {
+		return nil
+	}
+	vars := []string{"USER=" + id.Name, "LOGNAME=" + id.Name}
+	if id.Home != "" {
+		vars = append(vars, "HOME="+id.Home)
+	}
+	return vars
+}
+
+// prepareOutputs performs the privileged preparation of output mounts before
+// the drop. Optional outputs that were not mounted are created (and handed to
+// the reflex identity) so the reflex can write to them unconditionally.
+// Mounted outputs stay owned by the host; the plan has already checked that
+// they are writable by the reflex identity.
+func prepareOutputs(id *identity, outputs map[string]manifesttypes.PathSpec) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		path := filepath.Join(ioBasePath(), "output_"+name)
+		if _, err := os.Stat(path); !os.IsNotExist(err) || outputs[name].Required {
+			continue // Missing required outputs are reported by the plan
+		}
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return fmt.Errorf("creating optional output '%s': %w", name, err)
+		}
+		if id != nil {
+			if err := os.Chown(path, int(id.UID), int(id.GID)); err != nil {
+				return fmt.Errorf("handing optional output '%s' to reflex user: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writableBy reports whether the permission bits of info grant write and
+// search access to id.
+func writableBy(info os.FileInfo, id *identity) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	perm := info.Mode().Perm()
+	if st.Uid == id.UID {
+		return perm&0o300 == 0o300
+	}
+	for _, g := range append([]uint32{id.GID}, id.Groups...) {
+		if st.Gid == g {
+			return perm&0o030 == 0o030
+		}
+	}
+	return perm&0o003 == 0o003
+}
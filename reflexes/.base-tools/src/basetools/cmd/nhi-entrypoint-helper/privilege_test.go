@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestResolveReflexIdentity(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the privilege drop only happens when the helper runs as root")
+	}
+	tests := []struct {
+		name      string
+		owner     *callingOwner
+		allowRoot bool
+		wantUID   uint32
+		wantNil   bool
+		wantErr   bool
+	}{
+		{name: "calling user", owner: &callingOwner{UID: 65534, GID: 65534}, wantUID: 65534},
+		{name: "root allowed", owner: &callingOwner{UID: 65534, GID: 65534}, allowRoot: true, wantNil: true},
+		{name: "calling user is root", owner: &callingOwner{UID: 0, GID: 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := resolveReflexIdentity(tt.owner, tt.allowRoot)
+			switch {
+			case tt.wantErr:
+				if err == nil {
+					t.Fatalf("expected an error, got identity %+v", id)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantNil:
+				if id != nil {
+					t.Fatalf("expected no drop, got identity %+v", id)
+				}
+			case id == nil || id.UID != tt.wantUID:
+				t.Fatalf("got identity %+v, want UID %d", id, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestCheckPrivilegeDrop(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the privilege drop only happens when the helper runs as root")
+	}
+	tests := []struct {
+		name     string
+		id       *identity
+		wantPass bool
+	}{
+		{name: "drops to the calling user", id: &identity{UID: 65534, GID: 65534, Groups: []uint32{65534}}, wantPass: true},
+		{name: "no identity runs as root", id: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := checkPrivilegeDrop(slog.New(slog.NewTextHandler(io.Discard, nil)), tt.id)
+			if res.Passed != tt.wantPass {
+				t.Fatalf("passed = %v, want %v (failures: %v)", res.Passed, tt.wantPass, res.Failures)
+			}
+		})
+	}
+}
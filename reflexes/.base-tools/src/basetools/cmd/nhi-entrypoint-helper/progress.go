@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"nhi/basetools/pkg/progress"
+)
+
+// Descriptor the progress channel has in the reflex: the first of
+// exec.Cmd.ExtraFiles.
+const progressFD = 3
+
+// How long to wait for the remaining events once the reflex has exited. A
+// background process that inherited the channel could otherwise hold the
+// helper up.
+const progressDrainTimeout = time.Second
+
+// progressRelay reads the JSON progress events the reflex writes to
+// NHI_PROGRESS_FD and relays them as log records, at any log level. In tar mode, every valid
+// event is also recorded for the output tar.
+type progressRelay struct {
+	Writer *os.File // Passed to the reflex
+	reader *os.File
+	done   chan struct{}
+}
+
+// startProgressRelay opens the channel and starts relaying. record, when
+// non-nil, receives a copy of every valid event line.
+func startProgressRelay(logger *slog.Logger, record io.Writer) (*progressRelay, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating progress channel: %w", err)
+	}
+	p := &progressRelay{Writer: w, reader: r, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		err := progress.Scan(r, func(line []byte, e progress.Event, err error) {
+			if err != nil {
+				logger.Warn("Ignoring progress event", "error", err)
+				return
+			}
+			attrs := []any{}
+			if e.Phase != "" {
+				attrs = append(attrs, "phase", e.Phase)
+			}
+			if e.Percent != nil {
+				attrs = append(attrs, "percent", *e.Percent)
+			}
+			if e.Message != "" {
+				attrs = append(attrs, "message", e.Message)
+			}
+			relayProgress(logger, attrs...)
+			if record != nil {
+				_, _ = record.Write(append(line, '\n'))
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			logger.Warn("Stopped reading progress events", "error", err)
+		}
+	}()
+	return p, nil
+}
+
+// relayProgress logs one progress event whatever NHI_LOG_LEVEL is. Progress
+// is meant for the caller rather than a diagnostic, so it would be lost if the
+// default warn level filtered it; the record still goes through the logger's
+// handler, so NHI_LOG_FORMAT and NHI_LOG_FILE apply.
+func relayProgress(logger *slog.Logger, attrs ...any) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "Progress", 0)
+	r.Add(attrs...)
+	_ = logger.Handler().Handle(context.Background(), r)
+}
+
+// env returns the variable that points the reflex at the channel.
+func (p *progressRelay) env() []string {
+	return []string{progress.FDEnv + "=" + strconv.Itoa(progressFD)}
+}
+
+// finish closes the helper's copy of the channel once the reflex has exited
+// and waits briefly for the events still in flight.
+func (p *progressRelay) finish(logger *slog.Logger) {
+	if p == nil {
+		return
+	}
+	p.Writer.Close()
+	select {
+	case <-p.done:
+	case <-time.After(progressDrainTimeout):
+		logger.Warn("Progress channel still open after the reflex exited; ignoring further events")
+	}
+	p.reader.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Progress reaches the log at the default warn level; invalid events are
+// dropped with a warning.
+func TestProgressRelayDefaultLevel(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "helper.log")
+	t.Setenv("NHI_LOG_FILE", logFile)
+	t.Setenv("NHI_LOG_LEVEL", "")
+	t.Setenv("NHI_LOG_FORMAT", "")
+	logger, herr := newLogger("run")
+	if herr != nil {
+		t.Fatal(herr)
+	}
+	relay, err := startProgressRelay(logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.Writer.WriteString("{\"phase\":\"render\",\"percent\":40}\n{\"percent\":400}\n"); err != nil {
+		t.Fatal(err)
+	}
+	relay.finish(logger)
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, want := range []string{"msg=Progress run_id=run phase=render percent=40", "Ignoring progress event"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log does not contain %q:\n%s", want, log)
+		}
+	}
+	if strings.Count(log, "msg=Progress") != 1 {
+		t.Errorf("want exactly one progress record:\n%s", log)
+	}
+}
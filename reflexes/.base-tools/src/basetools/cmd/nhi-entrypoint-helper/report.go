@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// runReport summarizes a run for pipelines: how it ended, where its
+// parameters came from, how its hooks went and the scalar outputs it
+// produced. It is written to NHI_REPORT_FILE and, in tar mode,
+// added to the output tar.
+type runReport struct {
+	RunID      string                 `json:"run_id"`
+	Reflex     string                 `json:"reflex,omitempty"`
+	Version    string                 `json:"version,omitempty"`
+	Subcommand string                 `json:"subcommand,omitempty"`
+	ExitCode   int                    `json:"exit_code"`
+	Cached     bool                   `json:"cached"`
+	StartedAt  time.Time              `json:"started_at"`
+	Duration   time.Duration          `json:"duration_ns"`
+	Parameters map[string]string      `json:"parameters,omitempty"` // Declared variable -> source of its value; values are not included
+	Hooks      []hookResult           `json:"hooks,omitempty"`
+	Outputs    map[string]interface{} `json:"outputs"`
+	Errors     []*helperError         `json:"errors,omitempty"`
+}
+
+// marshal renders the report as indented JSON.
+func (r *runReport) marshal() ([]byte, error) {
+	if r.Outputs == nil {
+		r.Outputs = map[string]interface{}{} // Always an object, never null
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal run report: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// writeFile writes the report to path and hands it to owner, since the path
+// normally lies in a directory mounted from the host.
+func (r *runReport) writeFile(path string, owner *callingOwner) error {
+	data, err := r.marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing run report: %w", err)
+	}
+	if owner != nil && os.Geteuid() == 0 {
+		if err := os.Chown(path, owner.UID, owner.GID); err != nil {
+			return fmt.Errorf("handing run report to calling user: %w", err)
+		}
+	}
+	return nil
+}
+
+// deliverResults writes the report to NHI_REPORT_FILE when it is set and, in
+// tar mode, writes the output tar (which carries the report) to stdout. The
+// tar is the only way back in that mode, so it is written even for a failed
+// run.
+func deliverResults(logger *slog.Logger, r *runReport, tio *tarIO, outputs map[string]manifesttypes.PathSpec, owner *callingOwner) []*helperError {
+	var errs []*helperError
+	if path := os.Getenv("NHI_REPORT_FILE"); path != "" {
+		if err := r.writeFile(path, owner); err != nil {
+			errs = append(errs, internalError(err))
+		}
+	}
+	if tio != nil {
+		data, err := r.marshal()
+		if err == nil {
+			err = tio.writeOutputs(os.Stdout, outputs, data)
+		}
+		if err != nil {
+			errs = append(errs, internalError(err))
+		}
+		tio.cleanup(logger)
+	}
+	return errs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"nhi/basetools/pkg/examples"
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Directory the image bakes example fixtures into
+const selfTestFixtures = "/examples"
+
+// runSelfTest runs the examples declared in the manifest against the
+// fixtures baked into the image. Fixture paths are relative to the manifest
+// directory, /, and must resolve under /examples; an example whose fixtures
+// lie elsewhere fails. Each example runs
+// through this helper again, with NHI_IO_BASE pointing at fresh copies of its
+// fixtures; examples of a subcommand name it in their command field. A TAP
+// (default) or JSON summary is printed to stdout; the exit code is 1 if any
+// example fails.
+//
+// When the helper runs as root and the manifest does not allow root, a
+// "privilege drop" check comes first: it proves that the reflex command would
+// run as CALLING_UID/CALLING_GID or the nhi user, not as root.
+func runSelfTest(logger *slog.Logger, cmdArgs []string, format string) int {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		fail(internalError(fmt.Errorf("reading manifest %s: %w", manifestPath, err)))
+	}
+	var m manifesttypes.Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		fail(internalError(fmt.Errorf("parsing manifest %s: %w", manifestPath, err)))
+	}
+	checkDrop := os.Geteuid() == 0 && !m.AllowsRoot()
+	if len(m.Examples) == 0 && !checkDrop {
+		fail(newError(categoryUsage, "", "Declare examples: in manifest.yml and bake their fixtures under /examples",
+			"Manifest declares no examples to self-test"))
+	}
+	if len(cmdArgs) == 0 {
+		cmdArgs = m.Command
+	}
+	if len(cmdArgs) == 0 && len(m.Commands) == 0 && len(m.Examples) > 0 {
+		fail(newError(categoryUsage, "", "Set command: in manifest.yml or pass the reflex command",
+			"No command to self-test"))
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fail(internalError(fmt.Errorf("locating the helper: %w", err)))
+	}
+	// The examples run the helper normally, so self-test mode must not leak
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "NHI_SELFTEST") {
+			env = append(env, kv)
+		}
+	}
+
+	logger.Info("Running self-test", "examples", len(m.Examples), "cmd", cmdArgs)
+	runner := examples.Runner{
+		Manifest: m,
+		BaseDir:  filepath.Dir(manifestPath),
+		Fixtures: selfTestFixtures,
+		Command:  append([]string{self}, cmdArgs...),
+		Env:      env,
+		Stderr:   os.Stderr,
+	}
+	var results []examples.Result
+	if checkDrop {
+		owner, err := resolveCallingOwner()
+		if err != nil {
+			fail(newError(categoryUsage, "", "Set CALLING_UID and CALLING_GID to non-negative integers", "%v", err))
+		}
+		id, err := resolveReflexIdentity(owner, false)
+		if err != nil {
+			fail(newError(categoryUsage, "", "Pass -e CALLING_UID=$(id -u) -e CALLING_GID=$(id -g)", "%v", err))
+		}
+		results = append(results, checkPrivilegeDrop(logger, id))
+	}
+	results = append(results, runner.RunAll()...)
+	if err := examples.WriteReport(os.Stdout, results, format); err != nil {
+		fail(newError(categoryUsage, "", "Set NHI_SELFTEST_FORMAT to tap or json", "%v", err))
+	}
+	if _, failed := examples.Summary(results); failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkPrivilegeDrop runs `id -u` through executeCommand, the path that
+// starts the reflex, with the identity the helper drops to, and checks that
+// the process reports that identity.
+func checkPrivilegeDrop(logger *slog.Logger, id *identity) (res examples.Result) {
+	res.Name = "privilege drop"
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+	if id == nil {
+		res.Failures = append(res.Failures, "the reflex would run as root")
+		return res
+	}
+
+	var out bytes.Buffer
+	env := []string{"PATH=" + os.Getenv("PATH")}
+	code, herr := executeCommand(logger, "id", []string{"id", "-u"}, env, id, runLimits{Label: "privilege check"}, reflexIO{Stdout: &out})
+	if herr != nil || code != 0 {
+		res.Failures = append(res.Failures, fmt.Sprintf("running id -u as UID %d: exit code %d %v", id.UID, code, herr))
+		return res
+	}
+	uid, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	switch {
+	case err != nil:
+		res.Failures = append(res.Failures, fmt.Sprintf("unexpected output of id -u: %q", out.String()))
+	case uid == 0 || uid != int(id.UID):
+		res.Failures = append(res.Failures, fmt.Sprintf("the reflex ran as UID %d, expected %d", uid, id.UID))
+	default:
+		res.Passed = true
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// runSettings are the helper behaviours configured by the manifest's runtime
+// section. Each can be overridden by an NHI_* environment variable.
+type runSettings struct {
+	StageOutputs      bool          // NHI_STAGE_OUTPUTS
+	KeepFailedOutputs bool          // NHI_KEEP_FAILED_OUTPUTS
+	Timeout           time.Duration // NHI_TIMEOUT
+	TmpMaxBytes       int64         // NHI_TMPDIR_MAX_SIZE
+	KeepTmpOnFailure  bool          // NHI_KEEP_TMPDIR_ON_FAILURE
+	StrictEnvironment bool          // NHI_STRICT_ENV
+	CacheDir          string        // NHI_CACHE_DIR; caching is off when empty
+	CacheMaxBytes     int64         // NHI_CACHE_MAX_SIZE
+	IOMode            string        // NHI_IO: mount or tar
+}
+
+// resolveRunSettings combines the manifest's runtime section with the
+// environment overrides.
+func resolveRunSettings(rt *manifesttypes.RuntimeSpec) (runSettings, error) {
+	if rt == nil {
+		rt = &manifesttypes.RuntimeSpec{}
+	}
+	s := runSettings{
+		StageOutputs:      envBool("NHI_STAGE_OUTPUTS", rt.StageOutputs),
+		KeepFailedOutputs: envBool("NHI_KEEP_FAILED_OUTPUTS", rt.KeepFailedOutputs),
+		KeepTmpOnFailure:  envBool("NHI_KEEP_TMPDIR_ON_FAILURE", rt.KeepTmpOnFailure),
+		StrictEnvironment: envBool("NHI_STRICT_ENV", rt.StrictEnvironment),
+		CacheDir:          os.Getenv("NHI_CACHE_DIR"),
+	}
+
+	timeout := envOr("NHI_TIMEOUT", rt.Timeout)
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return s, fmt.Errorf("invalid timeout %q: expected a duration such as 90s or 10m", timeout)
+		}
+		s.Timeout = d
+	}
+
+	maxSize, err := parseSize(envOr("NHI_TMPDIR_MAX_SIZE", rt.TmpDirMaxSize))
+	if err != nil {
+		return s, fmt.Errorf("invalid temporary workspace size cap: %w", err)
+	}
+	s.TmpMaxBytes = maxSize
+
+	if s.IOMode, err = resolveIOMode(); err != nil {
+		return s, err
+	}
+
+	cacheMax, err := parseSize(os.Getenv("NHI_CACHE_MAX_SIZE"))
+	if err != nil {
+		return s, fmt.Errorf("invalid cache size cap: %w", err)
+	}
+	s.CacheMaxBytes = cacheMax
+	return s, nil
+}
+
+// envBool interprets a boolean helper setting from the environment, falling
+// back to def when the variable is unset or unrecognised.
+func envBool(name string, def bool) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	default:
+		return def
+	}
+}
+
+// envOr returns the value of the environment variable name, or def when it
+// is unset or empty.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
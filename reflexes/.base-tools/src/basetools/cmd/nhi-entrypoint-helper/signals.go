@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Termination signals the helper handles itself
+var terminationSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// signalGuard removes what a run has created when the helper is told to
+// stop. While a reflex or hook is supervised, signals are forwarded to it
+// instead and the run winds down normally once it exits. Inside a critical
+// section, such as publishing outputs, a signal is held back until the
+// section ends, so the mounts are never left half swapped.
+type signalGuard struct {
+	logger     *slog.Logger
+	mu         sync.Mutex
+	cleanups   []func() // Run in reverse order
+	critical   int      // Critical sections in progress
+	forwarding int      // Supervised commands in progress
+	pending    os.Signal
+}
+
+// runGuard is the guard of the current run; nil until the run installs it.
+// Its methods do nothing on nil, so code shared with other modes can use it.
+var runGuard *signalGuard
+
+// installSignalGuard starts handling termination signals for the rest of the
+// helper's life.
+func installSignalGuard(logger *slog.Logger) *signalGuard {
+	g := &signalGuard{logger: logger}
+	ch := make(chan os.Signal, 4)
+	signal.Notify(ch, terminationSignals...)
+	go func() {
+		for sig := range ch {
+			g.handle(sig)
+		}
+	}()
+	return g
+}
+
+func (g *signalGuard) handle(sig os.Signal) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.forwarding > 0:
+		// superviseCommand forwards it to the reflex
+	case g.critical > 0:
+		if g.pending == nil {
+			g.logger.Warn("Signal received; stopping once the current step is done", "signal", sig.String())
+			g.pending = sig
+		}
+	default:
+		g.exit(sig)
+	}
+}
+
+// exit runs the cleanups and exits as a process killed by sig would, as
+// shells report it. g.mu must be held.
+func (g *signalGuard) exit(sig os.Signal) {
+	g.logger.Warn("Interrupted; cleaning up", "signal", sig.String())
+	for i := len(g.cleanups) - 1; i >= 0; i-- {
+		g.cleanups[i]()
+	}
+	code := exitInternal
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	os.Exit(code)
+}
+
+// onInterrupt registers f to run if the helper is stopped by a signal. f
+// must be safe to run at any point of the run, including after the normal
+// cleanup of what it removes.
+func (g *signalGuard) onInterrupt(f func()) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanups = append(g.cleanups, f)
+}
+
+// enterCritical holds signals back until the matching leaveCritical.
+func (g *signalGuard) enterCritical() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.critical++
+}
+
+// leaveCritical ends a critical section and acts on a signal received during
+// it.
+func (g *signalGuard) leaveCritical() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.critical--
+	if g.critical == 0 && g.forwarding == 0 && g.pending != nil {
+		g.exit(g.pending)
+	}
+}
+
+// setForwarding marks a supervised command as started (true) or finished.
+func (g *signalGuard) setForwarding(on bool) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if on {
+		g.forwarding++
+	} else {
+		g.forwarding--
+	}
+}
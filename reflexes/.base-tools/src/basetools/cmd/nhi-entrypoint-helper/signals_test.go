@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSignalGuardDefersSignals(t *testing.T) {
+	tests := []struct {
+		name        string
+		critical    int
+		forwarding  int
+		wantPending bool
+	}{
+		{name: "critical section holds the signal", critical: 1, wantPending: true},
+		{name: "supervised command gets the signal", forwarding: 1},
+		{name: "supervised command inside a critical section", critical: 1, forwarding: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &signalGuard{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), critical: tt.critical, forwarding: tt.forwarding}
+			g.onInterrupt(func() { t.Fatal("cleanup ran while the signal should be deferred") })
+			g.handle(syscall.SIGTERM)
+			if got := g.pending != nil; got != tt.wantPending {
+				t.Fatalf("pending = %v, want %v", got, tt.wantPending)
+			}
+		})
+	}
+}
+
+// The interrupted helper exits, so that case runs in a child process
+func TestSignalGuardCleansUpAndExits(t *testing.T) {
+	if marker := os.Getenv("NHI_TEST_SIGNAL_MARKER"); marker != "" {
+		g := &signalGuard{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+		g.onInterrupt(func() { os.WriteFile(marker, []byte("first"), 0o644) })
+		g.onInterrupt(func() { os.WriteFile(marker, []byte("second"), 0o644) }) // Runs first
+		g.enterCritical()
+		g.handle(syscall.SIGTERM)
+		g.leaveCritical() // Acts on the held signal
+		os.Exit(0)
+	}
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSignalGuardCleansUpAndExits$")
+	cmd.Env = append(os.Environ(), "NHI_TEST_SIGNAL_MARKER="+marker)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 128+int(syscall.SIGTERM) {
+		t.Fatalf("child exited with %v, want exit code %d", err, 128+int(syscall.SIGTERM))
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil || string(data) != "first" {
+		t.Fatalf("cleanups did not run in reverse order: marker = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Prefixes of helper-managed directories inside an output mount. They are
+// never treated as reflex output when publishing.
+const (
+	stagingDirPrefix  = ".nhi-staging-"
+	previousDirPrefix = ".nhi-previous-"
+	failedDirPrefix   = ".failed-"
+)
+
+// newRunID returns a short random identifier for this helper invocation.
+func newRunID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating run id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// outputStage tracks the staging directory used for one output mount.
+type outputStage struct {
+	Name    string // Output name from the manifest
+	Mount   string // The real mount, e.g. /app/output_static_site
+	Staging string // Directory the reflex writes to instead
+}
+
+// createOutputStages creates a staging directory inside every output mount so
+// that publishing is a rename on the same filesystem. When the reflex runs
+// as a different identity, the staging directories are handed to it.
+func createOutputStages(outputs map[string]string, runID string, id *identity) ([]outputStage, error) {
+	var stages []outputStage
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		mount := outputs[name]
+		staging := filepath.Join(mount, stagingDirPrefix+runID)
+		if err := os.Mkdir(staging, 0o755); err != nil {
+			return stages, fmt.Errorf("creating staging directory for output '%s': %w", name, err)
+		}
+		stages = append(stages, outputStage{Name: name, Mount: mount, Staging: staging})
+		if id != nil {
+			if err := os.Chown(staging, int(id.UID), int(id.GID)); err != nil {
+				return stages, fmt.Errorf("handing staging directory for output '%s' to reflex user: %w", name, err)
+			}
+		}
+	}
+	return stages, nil
+}
+
+// validateStagedOutputs checks the staged content against the manifest before
+// it is published. A required output must not be left empty.
+func validateStagedOutputs(stages []outputStage, specs map[string]manifesttypes.PathSpec) *helperError {
+	for _, st := range stages {
+		spec, ok := specs[st.Name]
+		if !ok || !spec.Required {
+			continue
+		}
+		entries, err := os.ReadDir(st.Staging)
+		if err != nil {
+			return internalError(fmt.Errorf("reading staged output '%s': %w", st.Name, err))
+		}
+		if len(entries) == 0 {
+			return newError(categoryContractViolation, st.Name, "The reflex must write at least one entry to a required output",
+				"Required output '%s' is empty", st.Name)
+		}
+	}
+	return nil
+}
+
+// publishOutputs swaps staged content into the real mounts. Every entry is
+// moved by a rename within the mount's filesystem, so each published file
+// appears whole; the mount as a whole is not swapped atomically, and a reader
+// may briefly see a mix of old and new entries.
+//
+// Publishing is all or nothing across the outputs. First the previous
+// content of every mount is moved aside into .nhi-previous-<runid>, then the
+// staged entries of every mount are moved in. If any move fails, the entries
+// already published are moved back into their staging directories and the
+// previous content is restored, in every mount; the staging directories are
+// left for discardOutputs. The previous content is only removed once all
+// outputs are published.
+func publishOutputs(logger *slog.Logger, stages []outputStage, runID string) error {
+	// Read every stage before touching any mount
+	staged := make([][]string, len(stages))
+	for i, st := range stages {
+		names, err := entryNames(st.Staging, nil)
+		if err != nil {
+			return fmt.Errorf("publishing output '%s': reading staged content: %w", st.Name, err)
+		}
+		staged[i] = names
+	}
+
+	// Move the previous content of every mount aside
+	previous := make([]string, len(stages))
+	aside := make([][]string, len(stages))
+	rollback := func(published [][]string) {
+		for i := range stages {
+			if i < len(published) {
+				if err := moveNames(stages[i].Mount, stages[i].Staging, published[i]); err != nil {
+					logger.Error("Could not take back published output", "name", stages[i].Name, "error", err)
+				}
+			}
+			if previous[i] == "" {
+				continue
+			}
+			if err := moveNames(previous[i], stages[i].Mount, aside[i]); err != nil {
+				logger.Error("Could not restore previous output content", "name", stages[i].Name, "path", previous[i], "error", err)
+				continue
+			}
+			_ = os.Remove(previous[i])
+		}
+	}
+	for i, st := range stages {
+		dir := filepath.Join(st.Mount, previousDirPrefix+runID)
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			rollback(nil)
+			return fmt.Errorf("publishing output '%s': %w", st.Name, err)
+		}
+		previous[i] = dir
+		names, err := entryNames(st.Mount, isReflexEntry)
+		if err == nil {
+			err = moveNamesTracked(st.Mount, dir, names, &aside[i])
+		}
+		if err != nil {
+			rollback(nil)
+			return fmt.Errorf("publishing output '%s': moving previous content aside: %w", st.Name, err)
+		}
+	}
+
+	// Move the staged content of every mount in
+	published := make([][]string, len(stages))
+	for i, st := range stages {
+		logger.Info("Publishing staged output", "name", st.Name, "path", st.Mount)
+		if err := moveNamesTracked(st.Staging, st.Mount, staged[i], &published[i]); err != nil {
+			rollback(published)
+			return fmt.Errorf("publishing output '%s': %w", st.Name, err)
+		}
+	}
+
+	// Everything is published; what is left is housekeeping
+	for i, st := range stages {
+		if err := os.Remove(st.Staging); err != nil {
+			logger.Warn("Could not remove staging directory", "path", st.Staging, "error", err)
+		}
+		if err := os.RemoveAll(previous[i]); err != nil {
+			logger.Warn("Could not remove previous output content", "path", previous[i], "error", err)
+		}
+	}
+	return nil
+}
+
+// discardOutputs drops staged content after a failed run, leaving the mounts
+// untouched. With keep set, the staged content is kept for debugging under
+// .failed-<runid> inside the mount: a rename cannot leave the mount's
+// filesystem, and the caller can only see what is in the mount. Like the
+// other helper-managed directories it is never cached or streamed out as
+// tar output, but a step that publishes the whole mount must skip .failed-*.
+func discardOutputs(logger *slog.Logger, stages []outputStage, runID string, keep bool) error {
+	var firstErr error
+	for _, st := range stages {
+		var err error
+		if keep {
+			failed := filepath.Join(st.Mount, failedDirPrefix+runID)
+			logger.Warn("Keeping staged output of failed run", "name", st.Name, "path", failed)
+			err = os.Rename(st.Staging, failed)
+		} else {
+			logger.Info("Discarding staged output of failed run", "name", st.Name)
+			err = os.RemoveAll(st.Staging)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("discarding staged output '%s': %w", st.Name, err)
+		}
+	}
+	return firstErr
+}
+
+// isReflexEntry reports whether a mount entry is reflex output rather than a
+// directory managed by the helper.
+func isReflexEntry(name string) bool {
+	return !strings.HasPrefix(name, stagingDirPrefix) &&
+		!strings.HasPrefix(name, previousDirPrefix) &&
+		!strings.HasPrefix(name, failedDirPrefix)
+}
+
+// entryNames lists the entries of dir in lexical order. When keep is
+// non-nil, only entries it accepts are listed.
+func entryNames(dir string, keep func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(dir) // Sorted by name
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if keep == nil || keep(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// moveNamesTracked renames the named entries of src into dst, appending each
+// name to moved once it has been moved, so a failure can be undone.
+func moveNamesTracked(src, dst string, names []string, moved *[]string) error {
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+		*moved = append(*moved, name)
+	}
+	return nil
+}
+
+// moveNames renames the named entries of src into dst, carrying on past
+// failures so as much as possible is moved, and returns the first error.
+func moveNames(src, dst string, names []string) error {
+	var firstErr error
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(src, name), filepath.Join(dst, name)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
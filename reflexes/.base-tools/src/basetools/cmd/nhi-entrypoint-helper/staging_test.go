@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTree creates the files of tree (path -> content) under root.
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for path, content := range tree {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files under root as path -> content.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		tree[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func treeString(tree map[string]string) string {
+	var lines []string
+	for path, content := range tree {
+		lines = append(lines, path+"="+content)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ", ")
+}
+
+func TestPublishOutputs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name     string
+		mounts   []map[string]string // Content of each mount before the run
+		staged   []map[string]string // Content each stage wrote
+		wantErr  bool
+		want     []map[string]string // Content of each mount afterwards
+		wantLeft []map[string]string // Content left in each staging directory
+	}{
+		{
+			name:   "replaces the previous content of every mount",
+			mounts: []map[string]string{{"old.txt": "a"}, {"keep/old.txt": "b"}},
+			staged: []map[string]string{{"new.txt": "1"}, {"dir/new.txt": "2"}},
+			want:   []map[string]string{{"new.txt": "1"}, {"dir/new.txt": "2"}},
+		},
+		{
+			// The second stage cannot be published: its entry collides with a
+			// helper-managed directory that is not moved aside
+			name:     "failure on a later output restores every mount",
+			mounts:   []map[string]string{{"old.txt": "a"}, {"old.txt": "b", ".failed-x/f": "kept"}},
+			staged:   []map[string]string{{"new.txt": "1"}, {"a.txt": "2", ".failed-x/g": "clash"}},
+			wantErr:  true,
+			want:     []map[string]string{{"old.txt": "a"}, {"old.txt": "b", ".failed-x/f": "kept"}},
+			wantLeft: []map[string]string{{"new.txt": "1"}, {"a.txt": "2", ".failed-x/g": "clash"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stages []outputStage
+			for i := range tt.mounts {
+				mount := filepath.Join(t.TempDir(), "output")
+				staging := filepath.Join(mount, stagingDirPrefix+"run")
+				writeTree(t, mount, tt.mounts[i])
+				if err := os.MkdirAll(staging, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				writeTree(t, staging, tt.staged[i])
+				stages = append(stages, outputStage{Name: string(rune('a' + i)), Mount: mount, Staging: staging})
+			}
+
+			err := publishOutputs(logger, stages, "run")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publishOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, st := range stages {
+				got := readTree(t, st.Mount)
+				left := make(map[string]string)
+				for path, content := range got {
+					if rel := strings.TrimPrefix(path, stagingDirPrefix+"run/"); rel != path {
+						left[rel] = content
+						delete(got, path)
+					}
+				}
+				if treeString(got) != treeString(tt.want[i]) {
+					t.Errorf("mount %d = {%s}, want {%s}", i, treeString(got), treeString(tt.want[i]))
+				}
+				wantLeft := tt.wantLeft
+				if wantLeft == nil {
+					wantLeft = make([]map[string]string, len(stages))
+				}
+				if treeString(left) != treeString(wantLeft[i]) {
+					t.Errorf("staging %d = {%s}, want {%s}", i, treeString(left), treeString(wantLeft[i]))
+				}
+				if _, err := os.Stat(filepath.Join(st.Mount, previousDirPrefix+"run")); !os.IsNotExist(err) {
+					t.Errorf("mount %d still has its %s directory", i, previousDirPrefix)
+				}
+			}
+		})
+	}
+}
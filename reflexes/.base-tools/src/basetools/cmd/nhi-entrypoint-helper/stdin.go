@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+	"nhi/basetools/pkg/schema"
+)
+
+// Name used for stdin in helper errors
+const stdinName = "stdin"
+
+// stdinSource is what the reflex reads as stdin once the manifest's stdin
+// contract has been applied. JSON Lines input is validated as it streams
+// through; any other input is buffered and validated before the run.
+type stdinSource struct {
+	Reader io.Reader // Connected to the reflex
+	Digest string    // SHA-256 of buffered input, for the cache key; empty when streamed or unchecked
+	Opaque bool      // No contract, and stdin may hold input the helper never reads
+	file   *os.File  // Buffered copy
+	stream *jsonLinesReader
+}
+
+// mayHoldInput reports whether stdin r may carry input. A character device,
+// that is a terminal or the /dev/null of docker run without -i, and the empty
+// stdin of a tar run without a stdin member do not; a pipe or a file may.
+func mayHoldInput(r io.Reader) bool {
+	switch r := r.(type) {
+	case *os.File:
+		info, err := r.Stat()
+		return err != nil || info.Mode()&os.ModeCharDevice == 0
+	case *strings.Reader:
+		return r.Len() > 0
+	}
+	return true
+}
+
+// openStdin applies spec to r. Without a contract, r is passed through
+// unchecked, as it always was.
+func openStdin(spec *manifesttypes.PathSpec, r io.Reader) (*stdinSource, *helperError) {
+	if spec == nil {
+		return &stdinSource{Reader: r, Opaque: mayHoldInput(r)}, nil
+	}
+	maxBytes, err := parseSize(spec.MaxSize)
+	if err != nil {
+		return nil, newError(categoryUsage, stdinName, "Fix stdin.max_size in manifest.yml", "Invalid stdin size cap: %v", err)
+	}
+
+	br := bufio.NewReader(r)
+	if spec.Required {
+		if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+			return nil, newError(categoryMissingInput, stdinName, "Pipe the input in, e.g. cat input | docker run -i ...",
+				"Required stdin is empty")
+		}
+	}
+
+	if manifesttypes.IsJSONLines(spec.Format) {
+		stream := &jsonLinesReader{r: br, maxBytes: maxBytes, schema: spec.Schema}
+		return &stdinSource{Reader: stream, stream: stream}, nil
+	}
+
+	f, err := os.CreateTemp("", "nhi-stdin-")
+	if err != nil {
+		return nil, internalError(fmt.Errorf("buffering stdin: %w", err))
+	}
+	src := &stdinSource{file: f}
+	var in io.Reader = br
+	if maxBytes > 0 {
+		in = io.LimitReader(br, maxBytes+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), in)
+	if err != nil {
+		src.close()
+		return nil, internalError(fmt.Errorf("buffering stdin: %w", err))
+	}
+	if maxBytes > 0 && n > maxBytes {
+		src.close()
+		return nil, newError(categoryContractViolation, stdinName, "Send less input or raise stdin.max_size in manifest.yml",
+			"Stdin exceeds its size cap of %d bytes", maxBytes)
+	}
+	if strings.EqualFold(spec.Format, manifesttypes.FormatJSON) {
+		if herr := validateStdinJSON(f, spec.Schema); herr != nil {
+			src.close()
+			return nil, herr
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		src.close()
+		return nil, internalError(fmt.Errorf("buffering stdin: %w", err))
+	}
+	src.Reader, src.Digest = f, hex.EncodeToString(h.Sum(nil))
+	return src, nil
+}
+
+// validateStdinJSON checks that the buffered stdin is one JSON document that
+// matches the schema.
+func validateStdinJSON(f *os.File, sch interface{}) *helperError {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return internalError(fmt.Errorf("buffering stdin: %w", err))
+	}
+	dec := json.NewDecoder(f)
+	var v interface{}
+	err := dec.Decode(&v)
+	if err == nil && dec.More() {
+		err = fmt.Errorf("unexpected data after the JSON document")
+	}
+	if err != nil {
+		return newError(categoryContractViolation, stdinName, "Send a single JSON document on stdin", "Stdin is not valid JSON: %v", err)
+	}
+	if err := schema.Validate(sch, v); err != nil {
+		return newError(categoryContractViolation, stdinName, "Send input matching the stdin schema in manifest.yml",
+			"Stdin does not match its schema: %v", err)
+	}
+	return nil
+}
+
+// err returns the violation found while streaming, if any. It is only
+// meaningful once the reflex has exited.
+func (s *stdinSource) err() *helperError {
+	if s == nil || s.stream == nil {
+		return nil
+	}
+	return s.stream.violation
+}
+
+// close removes the buffered copy.
+func (s *stdinSource) close() {
+	if s == nil || s.file == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// jsonLinesReader passes JSON Lines through, validating every line before
+// the reflex can read it. On the first violation it records the error and
+// ends the stream, so the reflex sees end of input.
+type jsonLinesReader struct {
+	r         *bufio.Reader
+	maxBytes  int64 // 0 means unlimited
+	schema    interface{}
+	read      int64
+	line      int
+	pending   []byte // Validated data not yet read by the reflex
+	done      bool
+	violation *helperError
+}
+
+func (j *jsonLinesReader) Read(p []byte) (int, error) {
+	for len(j.pending) == 0 {
+		if j.done {
+			return 0, io.EOF
+		}
+		line, err := j.readLine()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if errors.Is(err, io.EOF) {
+			j.done = true
+		}
+		if len(line) == 0 {
+			continue
+		}
+		j.line++
+		j.read += int64(len(line))
+		if j.maxBytes > 0 && j.read > j.maxBytes {
+			return j.stop(newError(categoryContractViolation, stdinName, "Send less input or raise stdin.max_size in manifest.yml",
+				"Stdin exceeds its size cap of %d bytes", j.maxBytes))
+		}
+		if herr := j.check(line); herr != nil {
+			return j.stop(herr)
+		}
+		j.pending = line
+	}
+	n := copy(p, j.pending)
+	j.pending = j.pending[n:]
+	return n, nil
+}
+
+// readLine reads the next line. Past the size cap it returns what it has
+// read so far, so a line without end is never buffered whole.
+func (j *jsonLinesReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := j.r.ReadSlice('\n')
+		line = append(line, frag...)
+		if j.maxBytes > 0 && j.read+int64(len(line)) > j.maxBytes {
+			return line, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
+
+// check validates one line; blank lines are allowed.
+func (j *jsonLinesReader) check(line []byte) *helperError {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(trimmed, &v); err != nil {
+		return newError(categoryContractViolation, stdinName, "Send one JSON document per line on stdin",
+			"Stdin line %d is not valid JSON: %v", j.line, err)
+	}
+	if err := schema.Validate(j.schema, v); err != nil {
+		return newError(categoryContractViolation, stdinName, "Send lines matching the stdin schema in manifest.yml",
+			"Stdin line %d does not match its schema: %v", j.line, err)
+	}
+	return nil
+}
+
+// stop records a violation and ends the stream.
+func (j *jsonLinesReader) stop(e *helperError) (int, error) {
+	j.violation, j.done, j.pending = e, true, nil
+	return 0, io.EOF
+}
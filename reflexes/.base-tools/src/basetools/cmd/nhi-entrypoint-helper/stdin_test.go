@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesReader(t *testing.T) {
+	objectSchema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"id"},
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{"type": "integer"},
+		},
+	}
+	tests := []struct {
+		name          string
+		input         string
+		maxBytes      int64
+		schema        interface{}
+		want          string // What the reflex reads
+		wantViolation string
+	}{
+		{name: "valid lines", input: "{\"id\": 1}\n\n{\"id\": 2}", schema: objectSchema, want: "{\"id\": 1}\n\n{\"id\": 2}"},
+		{name: "not JSON", input: "{\"id\": 1}\nnope\n{\"id\": 3}\n", want: "{\"id\": 1}\n", wantViolation: "line 2 is not valid JSON"},
+		{name: "schema violation", input: "{\"id\": 1}\n{\"id\": \"two\"}\n", schema: objectSchema, want: "{\"id\": 1}\n", wantViolation: "line 2 does not match its schema"},
+		{name: "missing required property", input: "{}\n", schema: objectSchema, wantViolation: "line 1 does not match its schema"},
+		{name: "size cap at a line end", input: "{\"id\": 1}\n{\"id\": 2}\n", maxBytes: 20, want: "{\"id\": 1}\n{\"id\": 2}\n"},
+		{name: "size cap hit mid-line", input: "{\"id\": 1}\n{\"id\": 22}\n", maxBytes: 15, want: "{\"id\": 1}\n", wantViolation: "size cap of 15 bytes"},
+		{
+			// Longer than the buffer of the bufio.Reader, without a newline
+			name:          "endless line",
+			input:         "[" + strings.Repeat("1,", 10000),
+			maxBytes:      100,
+			wantViolation: "size cap of 100 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &jsonLinesReader{r: bufio.NewReaderSize(strings.NewReader(tt.input), 16), maxBytes: tt.maxBytes, schema: tt.schema}
+			got, err := io.ReadAll(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("reflex read %q, want %q", got, tt.want)
+			}
+			switch {
+			case tt.wantViolation == "" && j.violation != nil:
+				t.Errorf("unexpected violation: %v", j.violation)
+			case tt.wantViolation != "" && (j.violation == nil || !strings.Contains(j.violation.Error(), tt.wantViolation)):
+				t.Errorf("violation = %v, want one mentioning %q", j.violation, tt.wantViolation)
+			}
+			if tt.maxBytes > 0 && j.read > tt.maxBytes+16 {
+				t.Errorf("read %d bytes past a cap of %d", j.read, tt.maxBytes)
+			}
+		})
+	}
+}
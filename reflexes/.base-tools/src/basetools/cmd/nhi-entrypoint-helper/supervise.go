@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Exit code used when the reflex is stopped for exceeding its timeout,
+// matching timeout(1).
+const exitTimeout = 124
+
+// Time the reflex is given to exit after SIGTERM before it is killed.
+const killGracePeriod = 10 * time.Second
+
+// How often the workspace size cap is checked.
+const sizeCheckInterval = time.Second
+
+// runLimits bounds a reflex run. The zero value imposes no limits.
+type runLimits struct {
+	Timeout   time.Duration // 0 means no timeout
+	Workspace *workspace    // Supplies the tmp size cap; nil means no cap
+	Label     string        // Names what runs in messages, e.g. a hook; empty means the reflex
+}
+
+// superviseCommand runs cmd in its own process group and waits for it. While
+// it runs, termination signals received by the helper are forwarded to the
+// group, so the helper survives to clean up after the reflex. When a limit
+// is exceeded the group gets SIGTERM, then SIGKILL after a grace period.
+// It returns the exit code the helper should exit with, along with the error
+// when the helper failed or stopped the reflex itself.
+func superviseCommand(logger *slog.Logger, cmd *exec.Cmd, limits runLimits) (int, *helperError) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	// The run's signal guard leaves signals to this loop while it runs
+	runGuard.setForwarding(true)
+	defer runGuard.setForwarding(false)
+	sigCh := make(chan os.Signal, 4)
+	signal.Notify(sigCh, terminationSignals...)
+	defer signal.Stop(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		e := internalError(fmt.Errorf("starting reflex: %w", err))
+		return e.Code, e
+	}
+	pgid := cmd.Process.Pid
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeoutC, sizeC, killC <-chan time.Time
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	if limits.Workspace != nil && limits.Workspace.MaxBytes > 0 {
+		ticker := time.NewTicker(sizeCheckInterval)
+		defer ticker.Stop()
+		sizeC = ticker.C
+	}
+
+	subject, timeoutHint := "Reflex", "Raise runtime.timeout in the manifest or set NHI_TIMEOUT"
+	if limits.Label != "" {
+		subject, timeoutHint = limits.Label, "Raise the hook's timeout, or runtime.timeout, in the manifest"
+	}
+
+	var stopErr *helperError // Set when the helper stops the reflex itself
+	stop := func(e *helperError) {
+		stopErr = e
+		logger.Warn("Stopping reflex", "reason", e.Message)
+		_ = syscall.Kill(-pgid, syscall.SIGTERM)
+		killC = time.After(killGracePeriod)
+	}
+
+	for {
+		select {
+		case err := <-done:
+			if stopErr != nil {
+				return stopErr.Code, stopErr
+			}
+			var exitErr *exec.ExitError
+			if err != nil && !errors.As(err, &exitErr) {
+				e := internalError(fmt.Errorf("waiting for reflex: %w", err))
+				return e.Code, e
+			}
+			return exitCodeOf(err), nil
+		case sig := <-sigCh:
+			logger.Warn("Forwarding signal to reflex", "signal", sig.String())
+			_ = syscall.Kill(-pgid, sig.(syscall.Signal))
+		case <-timeoutC:
+			timeoutC = nil
+			stop(newError(categoryTimeout, "", timeoutHint, "%s exceeded its timeout of %s", subject, limits.Timeout))
+		case <-sizeC:
+			if over, size := limits.Workspace.overLimit(); over {
+				sizeC = nil
+				stop(newError(categoryContractViolation, "", "Raise runtime.tmpdir_max_size in the manifest or set NHI_TMPDIR_MAX_SIZE",
+					"%s grew the temporary workspace to %d bytes, over its cap of %d bytes", subject, size, limits.Workspace.MaxBytes))
+			}
+		case <-killC:
+			killC = nil
+			logger.Warn("Reflex did not exit after SIGTERM; killing it", "grace_period", killGracePeriod)
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}
+}
+
+// exitCodeOf converts the result of cmd.Wait into the reflex's exit code. A
+// reflex killed by a signal is reported as 128+signal, as shells do.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return exitInternal
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// I/O modes selected with NHI_IO
+const (
+	ioModeMount = "mount" // Inputs and outputs are bind mounts under /app
+	ioModeTar   = "tar"   // Inputs arrive as a tar on stdin, outputs leave as a tar on stdout
+)
+
+// Names of the tar members that carry the reflex's own stdout and the run
+// report in tar mode. Output names never start with a dot, so they cannot
+// clash with an output.
+const (
+	tarStdoutMember   = ".stdout"
+	tarReportMember   = ".report.json"
+	tarStdinMember    = ".stdin" // In the input tar: what the reflex reads as stdin
+	tarProgressMember = ".progress.jsonl"
+)
+
+// Default caps on the input tar, overridable with NHI_TAR_MAX_SIZE and
+// NHI_TAR_MAX_ENTRIES.
+const (
+	defaultTarMaxBytes   = 1 << 30
+	defaultTarMaxEntries = 100000
+)
+
+// resolveIOMode returns the I/O mode selected with NHI_IO.
+func resolveIOMode() (string, error) {
+	switch mode := strings.ToLower(envOr("NHI_IO", ioModeMount)); mode {
+	case ioModeMount, ioModeTar:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("NHI_IO: unsupported I/O mode %q (want mount or tar)", mode)
+	}
+}
+
+// tarLimits bounds what extractTarInputs accepts.
+type tarLimits struct {
+	MaxBytes   int64 // Total size of all regular files
+	MaxEntries int
+}
+
+// resolveTarLimits reads the input tar caps from the environment.
+func resolveTarLimits() (tarLimits, error) {
+	limits := tarLimits{MaxBytes: defaultTarMaxBytes, MaxEntries: defaultTarMaxEntries}
+	if v := os.Getenv("NHI_TAR_MAX_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil || n == 0 {
+			return limits, fmt.Errorf("NHI_TAR_MAX_SIZE: invalid size %q", v)
+		}
+		limits.MaxBytes = n
+	}
+	if v := os.Getenv("NHI_TAR_MAX_ENTRIES"); v != "" {
+		var n int
+		if _, err := fmt.Sscanf(v, "%d", &n); err != nil || n <= 0 {
+			return limits, fmt.Errorf("NHI_TAR_MAX_ENTRIES: invalid count %q", v)
+		}
+		limits.MaxEntries = n
+	}
+	return limits, nil
+}
+
+// tarIO is the private IO base used in tar mode. It takes the place of /app:
+// inputs are extracted to input_<name> and the reflex writes to
+// output_<name>, exactly as it would with bind mounts.
+type tarIO struct {
+	Base     string   // Exported as NHI_IO_BASE
+	Stdout   *os.File // Captures the reflex's stdout until the output tar is written
+	Progress *os.File // Records the reflex's progress events for the output tar
+}
+
+// newTarIO creates the IO base, extracts the input tar from r into it and
+// creates an empty directory for every declared output, handed to id.
+func newTarIO(r io.Reader, m manifesttypes.Manifest, limits tarLimits, id *identity) (*tarIO, error) {
+	base, err := os.MkdirTemp("", "nhi-io-")
+	if err != nil {
+		return nil, fmt.Errorf("creating tar I/O directory: %w", err)
+	}
+	t := &tarIO{Base: base}
+	if err := os.Chmod(base, 0o755); err != nil {
+		t.cleanup(nil)
+		return nil, err
+	}
+	if err := extractTarInputs(r, base, m.InputPaths, limits); err != nil {
+		t.cleanup(nil)
+		return nil, err
+	}
+	for _, name := range manifesttypes.SortedKeys(m.OutputPaths) {
+		dir := filepath.Join(base, "output_"+name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.cleanup(nil)
+			return nil, fmt.Errorf("creating output '%s': %w", name, err)
+		}
+		if id != nil {
+			if err := os.Chown(dir, int(id.UID), int(id.GID)); err != nil {
+				t.cleanup(nil)
+				return nil, fmt.Errorf("handing output '%s' to reflex user: %w", name, err)
+			}
+		}
+	}
+	if t.Stdout, err = os.CreateTemp("", "nhi-stdout-"); err != nil {
+		t.cleanup(nil)
+		return nil, fmt.Errorf("creating stdout capture: %w", err)
+	}
+	if t.Progress, err = os.CreateTemp("", "nhi-progress-"); err != nil {
+		t.cleanup(nil)
+		return nil, fmt.Errorf("creating progress capture: %w", err)
+	}
+	return t, nil
+}
+
+// extractTarInputs extracts r below base. Every top-level entry must be named
+// after a declared input and lands at base/input_<name>; a regular file makes
+// a file input, a directory a directory input. A top-level tarStdinMember
+// file is kept at base/.stdin for the reflex's stdin. Entries that would leave their
+// input (absolute paths, "..", symlinks pointing outside it) are rejected, as
+// are hard links and device files. Extracted content is readable by everyone
+// and owned by the helper, like a read-only mount.
+func extractTarInputs(r io.Reader, base string, inputs map[string]manifesttypes.PathSpec, limits tarLimits) error {
+	tr := tar.NewReader(r)
+	var total int64
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading input tar: %w", err)
+		}
+		if entries >= limits.MaxEntries {
+			return fmt.Errorf("input tar has more than %d entries", limits.MaxEntries)
+		}
+
+		name, err := cleanTarName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == tarStdinMember {
+			if hdr.Typeflag != tar.TypeReg || hdr.Size > limits.MaxBytes-total {
+				return fmt.Errorf("input tar entry %q: expected a regular file within the size cap", hdr.Name)
+			}
+			total += hdr.Size
+			if err := extractTarFile(tr, filepath.Join(base, tarStdinMember), hdr); err != nil {
+				return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+			}
+			continue
+		}
+		top, _, _ := strings.Cut(name, "/")
+		if _, ok := inputs[top]; !ok {
+			return fmt.Errorf("input tar entry %q: '%s' is not an input declared in the manifest", hdr.Name, top)
+		}
+		if err := checkNoSymlinkParents(base, name); err != nil {
+			return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+		}
+		target := filepath.Join(base, "input_"+filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+			}
+		case tar.TypeReg:
+			if hdr.Size > limits.MaxBytes-total {
+				return fmt.Errorf("input tar exceeds its size cap of %d bytes", limits.MaxBytes)
+			}
+			total += hdr.Size
+			if err := extractTarFile(tr, target, hdr); err != nil {
+				return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+			}
+		case tar.TypeSymlink:
+			if !symlinkStaysWithin(name, hdr.Linkname) {
+				return fmt.Errorf("input tar entry %q: symlink target %q leaves input '%s'", hdr.Name, hdr.Linkname, top)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("input tar entry %q: %w", hdr.Name, err)
+			}
+		default:
+			return fmt.Errorf("input tar entry %q: unsupported entry type %q", hdr.Name, string(hdr.Typeflag))
+		}
+	}
+}
+
+// cleanTarName normalizes a tar member name and rejects names that are
+// absolute or climb out of the archive root.
+func cleanTarName(name string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || strings.ContainsRune(clean, '\\') {
+		return "", fmt.Errorf("input tar entry %q: unsafe path", name)
+	}
+	return clean, nil
+}
+
+// checkNoSymlinkParents makes sure no directory above name has been
+// extracted as a symlink, so nothing is ever written through one and
+// symlinkStaysWithin can judge targets lexically.
+func checkNoSymlinkParents(base, name string) error {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		p := filepath.Join(base, "input_"+filepath.FromSlash(path.Join(parts[:i]...)))
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s is a symlink", path.Join(parts[:i]...))
+		}
+	}
+	return nil
+}
+
+// symlinkStaysWithin reports whether a symlink at name pointing to target
+// resolves inside the input that contains it.
+func symlinkStaysWithin(name, target string) bool {
+	if path.IsAbs(target) {
+		return false
+	}
+	top, _, _ := strings.Cut(name, "/")
+	resolved := path.Join(path.Dir(name), target)
+	return resolved == top || strings.HasPrefix(resolved, top+"/")
+}
+
+// extractTarFile writes the current member to target. Parent directories are
+// created as needed and an existing path is never overwritten.
+func extractTarFile(r io.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	perm := fs.FileMode(0o644)
+	if hdr.FileInfo().Mode()&0o111 != 0 {
+		perm = 0o755
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, hdr.Size); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, perm) // Not subject to the umask
+}
+
+// stdin returns what the reflex reads as stdin: the tarStdinMember of the
+// input tar, or no input at all.
+func (t *tarIO) stdin() (io.Reader, error) {
+	f, err := os.Open(filepath.Join(t.Base, tarStdinMember))
+	if errors.Is(err, fs.ErrNotExist) {
+		return strings.NewReader(""), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening stdin from the input tar: %w", err)
+	}
+	return f, nil
+}
+
+// writeOutputs writes the output tar to w: one top-level directory per
+// declared output, holding the reflex's entries, followed by the captured
+// stdout as the tarStdoutMember, the progress events as the
+// tarProgressMember and the run report as the tarReportMember.
+// Helper-managed directories inside an output are skipped.
+func (t *tarIO) writeOutputs(w io.Writer, outputs map[string]manifesttypes.PathSpec, report []byte) error {
+	tw := tar.NewWriter(w)
+	for _, name := range manifesttypes.SortedKeys(outputs) {
+		root := filepath.Join(t.Base, "output_"+name)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if rel != "." && !strings.Contains(rel, string(filepath.Separator)) && !isReflexEntry(rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return addTarEntry(tw, p, path.Join(name, filepath.ToSlash(rel)), d)
+		})
+		if err != nil {
+			return fmt.Errorf("writing output '%s' to tar: %w", name, err)
+		}
+	}
+
+	if err := addTarCapture(tw, tarStdoutMember, t.Stdout); err != nil {
+		return fmt.Errorf("writing stdout to tar: %w", err)
+	}
+	if err := addTarCapture(tw, tarProgressMember, t.Progress); err != nil {
+		return fmt.Errorf("writing progress events to tar: %w", err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: tarReportMember, Mode: 0o644, Size: int64(len(report)), ModTime: time.Now()}); err != nil {
+		return fmt.Errorf("writing run report to tar: %w", err)
+	}
+	if _, err := tw.Write(report); err != nil {
+		return fmt.Errorf("writing run report to tar: %w", err)
+	}
+	return tw.Close()
+}
+
+// addTarCapture writes the whole content of a capture file to tw as name.
+func addTarCapture(tw *tar.Writer, name string, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// addTarEntry writes the file at p to tw under name. Ownership is not
+// recorded, since it means nothing to the caller.
+func addTarEntry(tw *tar.Writer, p, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// cleanup removes the IO base and the captures.
+func (t *tarIO) cleanup(logger *slog.Logger) {
+	if t == nil {
+		return
+	}
+	for _, f := range []*os.File{t.Stdout, t.Progress} {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err := os.RemoveAll(t.Base); err != nil && logger != nil {
+		logger.Warn("Could not remove tar I/O directory", "path", t.Base, "error", err)
+	}
+}
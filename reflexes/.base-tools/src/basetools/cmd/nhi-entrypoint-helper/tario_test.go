@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// tarEntry is one member of a test tar. A non-empty Link makes a symlink and
+// a name ending in / a directory.
+type tarEntry struct {
+	Name    string
+	Content string
+	Link    string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.Content))}
+		switch {
+		case e.Link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.Link, 0
+		case strings.HasSuffix(e.Name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.Content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarInputs(t *testing.T) {
+	inputs := map[string]manifesttypes.PathSpec{"src": {Type: "directory"}, "config": {Type: "file"}}
+	limits := tarLimits{MaxBytes: 10, MaxEntries: 4}
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		limits  *tarLimits
+		wantErr string
+		want    map[string]string // Files expected under base, relative path -> content
+	}{
+		{
+			name:    "inputs and stdin",
+			entries: []tarEntry{{Name: "src/"}, {Name: "./src/a.txt", Content: "a"}, {Name: "config", Content: "c"}, {Name: ".stdin", Content: "in"}},
+			want:    map[string]string{"input_src/a.txt": "a", "input_config": "c", ".stdin": "in"},
+		},
+		{
+			name:    "symlink inside the input",
+			entries: []tarEntry{{Name: "src/a.txt", Content: "a"}, {Name: "src/sub/link", Link: "../a.txt"}},
+			want:    map[string]string{"input_src/a.txt": "a", "input_src/sub/link": "a"},
+		},
+		{name: "parent directory", entries: []tarEntry{{Name: "../evil", Content: "x"}}, wantErr: "unsafe path"},
+		{name: "climbs out through an input", entries: []tarEntry{{Name: "src/../../evil", Content: "x"}}, wantErr: "unsafe path"},
+		{name: "absolute path", entries: []tarEntry{{Name: "/etc/passwd", Content: "x"}}, wantErr: "unsafe path"},
+		{name: "undeclared input", entries: []tarEntry{{Name: "other/a", Content: "x"}}, wantErr: "not an input declared"},
+		{name: "absolute symlink", entries: []tarEntry{{Name: "src/link", Link: "/etc/passwd"}}, wantErr: "leaves input"},
+		{name: "symlink escapes the input", entries: []tarEntry{{Name: "src/link", Link: "../config"}}, wantErr: "leaves input"},
+		{
+			name:    "write through a symlinked directory",
+			entries: []tarEntry{{Name: "src/dir/"}, {Name: "src/link", Link: "dir"}, {Name: "src/link/a", Content: "x"}},
+			wantErr: "is a symlink",
+		},
+		{
+			name:    "size cap",
+			entries: []tarEntry{{Name: "src/a", Content: "123456"}, {Name: "src/b", Content: "789012"}},
+			wantErr: "size cap",
+		},
+		{
+			name:    "size cap on stdin",
+			entries: []tarEntry{{Name: ".stdin", Content: "12345678901"}},
+			wantErr: "within the size cap",
+		},
+		{
+			name:    "entry cap",
+			entries: []tarEntry{{Name: "src/"}, {Name: "src/a"}, {Name: "src/b"}, {Name: "src/c"}, {Name: "src/d"}},
+			wantErr: "more than 4 entries",
+		},
+		{
+			name:    "entries at the cap",
+			entries: []tarEntry{{Name: "src/"}, {Name: "src/a"}, {Name: "src/b"}, {Name: "src/c"}, {Name: "src/d"}},
+			limits:  &tarLimits{MaxBytes: 10, MaxEntries: 5},
+			want:    map[string]string{"input_src/a": "", "input_src/b": "", "input_src/c": "", "input_src/d": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			l := limits
+			if tt.limits != nil {
+				l = *tt.limits
+			}
+			err := extractTarInputs(buildTar(t, tt.entries), base, inputs, l)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				if _, err := os.Stat(filepath.Join(filepath.Dir(base), "evil")); err == nil {
+					t.Fatal("an entry was written outside the base")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readTree(t, base); treeString(got) != treeString(tt.want) {
+				t.Fatalf("extracted {%s}, want {%s}", treeString(got), treeString(tt.want))
+			}
+		})
+	}
+}
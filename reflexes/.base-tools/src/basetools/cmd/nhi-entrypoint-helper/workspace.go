@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// workspace is the per-run scratch area managed by the helper. Its tmp
+// directory is exported to the reflex as NHI_TMPDIR and TMPDIR; staged input
+// copies and the scalar outputs file live beside it. The whole tree is
+// removed when the run ends.
+type workspace struct {
+	Root        string
+	TmpDir      string // Exported as NHI_TMPDIR and TMPDIR
+	InputsDir   string // Parent of staged input copies, created on demand
+	OutputsFile string // Exported as NHI_OUTPUTS
+	MaxBytes    int64  // Size cap for TmpDir; 0 means unlimited
+}
+
+// newWorkspace creates a unique workspace for runID and hands it to id.
+func newWorkspace(runID string, maxBytes int64, id *identity) (*workspace, error) {
+	root, err := os.MkdirTemp("", "nhi-run-"+runID+"-")
+	if err != nil {
+		return nil, fmt.Errorf("creating run workspace: %w", err)
+	}
+	ws := &workspace{
+		Root:        root,
+		TmpDir:      filepath.Join(root, "tmp"),
+		InputsDir:   filepath.Join(root, "inputs"),
+		OutputsFile: filepath.Join(root, "outputs"),
+		MaxBytes:    maxBytes,
+	}
+	if err := os.Mkdir(ws.TmpDir, 0o700); err != nil {
+		os.RemoveAll(root)
+		return nil, fmt.Errorf("creating run workspace: %w", err)
+	}
+	if err := os.WriteFile(ws.OutputsFile, nil, 0o600); err != nil {
+		os.RemoveAll(root)
+		return nil, fmt.Errorf("creating run workspace: %w", err)
+	}
+	if id != nil {
+		for _, p := range []string{ws.Root, ws.TmpDir, ws.OutputsFile} {
+			if err := os.Chown(p, int(id.UID), int(id.GID)); err != nil {
+				os.RemoveAll(root)
+				return nil, fmt.Errorf("handing run workspace to reflex user: %w", err)
+			}
+		}
+	}
+	return ws, nil
+}
+
+// env returns the variables that point the reflex at the workspace.
+func (ws *workspace) env() []string {
+	return []string{"NHI_TMPDIR=" + ws.TmpDir, "TMPDIR=" + ws.TmpDir, "NHI_OUTPUTS=" + ws.OutputsFile}
+}
+
+// overLimit reports whether the tmp directory has grown past its cap, along
+// with its current size.
+func (ws *workspace) overLimit() (bool, int64) {
+	if ws == nil || ws.MaxBytes <= 0 {
+		return false, 0
+	}
+	size := dirSize(ws.TmpDir)
+	return size > ws.MaxBytes, size
+}
+
+// cleanup removes the workspace, unless keep is set, in which case its
+// location is logged so a failed run can be inspected.
+func (ws *workspace) cleanup(logger *slog.Logger, keep bool) {
+	if ws == nil {
+		return
+	}
+	if keep {
+		logger.Warn("Keeping run workspace of failed run", "path", ws.Root)
+		return
+	}
+	if err := os.RemoveAll(ws.Root); err != nil {
+		logger.Warn("Could not remove run workspace", "path", ws.Root, "error", err)
+	}
+}
+
+// dirSize returns the apparent size of all regular files below root.
+// Entries that disappear while walking are ignored.
+func dirSize(root string) int64 {
+	var total int64
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// Size syntax: a number, then optionally a binary unit (K, M, G or T, with
+// an optional i) and a B, e.g. "512M", "2GiB" or "100B"
+var sizePattern = regexp.MustCompile(`^(\d+)\s*(?:([KMGT])I?)?B?$`)
+
+// parseSize parses a byte size such as "1048576", "512M" or "2GiB". Suffixes
+// are binary multiples (K = 1024); any other suffix is rejected. Sizes that
+// do not fit in an int64 are rejected rather than wrapped.
+func parseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	if str == "" {
+		return 0, nil
+	}
+	m := sizePattern.FindStringSubmatch(str)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier := int64(1)
+	switch m[2] {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "1048576", want: 1048576},
+		{in: "512M", want: 512 << 20},
+		{in: "2GiB", want: 2 << 30},
+		{in: "10k", want: 10 << 10},
+		{in: "1T", want: 1 << 40},
+		{in: "8388607T", want: 8388607 << 40},
+		{in: "8388608T", wantErr: true}, // 2^63 bytes
+		{in: "9223372036854775807", want: 9223372036854775807},
+		{in: "9223372036854775808", wantErr: true},
+		{in: "-1M", wantErr: true},
+		{in: "lots", wantErr: true},
+		{in: "100B", want: 100},
+		{in: "2 KiB", want: 2 << 10},
+		{in: "512MB", want: 512 << 20},
+		{in: "512I", wantErr: true},
+		{in: "512IB", wantErr: true},
+		{in: "512X", wantErr: true},
+		{in: "512KK", wantErr: true},
+		{in: "1.5G", wantErr: true},
+		{in: "M", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
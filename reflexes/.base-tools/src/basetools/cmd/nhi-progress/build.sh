@@ -0,0 +1,8 @@
+#!/bin/sh
+set -e
+
+# Build the static binary for the progress reporting shim
+# Assumes go.sum and potentially vendor/ exist from local generation
+CGO_ENABLED=0 go build -ldflags "$(./ldflags.sh)" -o ./cmd/nhi-progress/nhi-progress ./cmd/nhi-progress
+
+echo "Build complete: nhi-progress"
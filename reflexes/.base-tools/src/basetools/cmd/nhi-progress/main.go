@@ -0,0 +1,55 @@
+// Command nhi-progress reports progress from shell-based reflexes through the
+// entrypoint helper's progress channel:
+//
+//	nhi-progress --phase render --percent 40 "Rendered 12 of 30 pages"
+//
+// Outside the helper, where NHI_PROGRESS_FD is not set, it does nothing.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"nhi/basetools/pkg/buildinfo"
+	"nhi/basetools/pkg/progress"
+)
+
+// Exit code for bad arguments, matching the helper's
+const exitUsage = 64
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nhi-progress [--phase NAME] [--percent 0-100] [message...] | --version")
+		flag.PrintDefaults()
+	}
+	phase := flag.String("phase", "", "Name of the current step")
+	percent := flag.Float64("percent", -1, "Overall completion, 0 to 100")
+	version := flag.Bool("version", false, "Print the basetools version and exit")
+	flag.Parse()
+	if *version {
+		fmt.Println(buildinfo.String("nhi-progress"))
+		return
+	}
+
+	e := progress.Event{Phase: *phase, Message: strings.Join(flag.Args(), " ")}
+	if *percent >= 0 {
+		e.Percent = percent
+	}
+	if err := e.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "nhi-progress: %v\n", err)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	r, err := progress.FromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nhi-progress: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if err := r.Report(e); err != nil {
+		// Progress is best effort; never fail the reflex over it
+		fmt.Fprintf(os.Stderr, "nhi-progress: %v\n", err)
+	}
+}
@@ -0,0 +1,14 @@
+#!/bin/sh
+# Prints the -ldflags that stamp a basetools binary with the toolchain
+# version (from VERSION), the commit and the build date. The commit comes
+# from BASETOOLS_COMMIT, set by the Dockerfile's build argument, or from git
+# when building from a checkout.
+set -e
+
+cd "$(dirname "$0")"
+pkg=nhi/basetools/pkg/buildinfo
+version=$(cat VERSION)
+commit=${BASETOOLS_COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo unknown)}
+date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+
+echo "-extldflags \"-static\" -X $pkg.Version=$version -X $pkg.Commit=$commit -X $pkg.Date=$date"
@@ -0,0 +1,100 @@
+// Package buildinfo holds the version, commit and build date stamped into the
+// basetools binaries at build time, and checks a manifest's
+// requires_basetools constraint against them.
+//
+// The values are set by ldflags.sh:
+//
+//	go build -ldflags "-X nhi/basetools/pkg/buildinfo.Version=1.2.0 ..."
+//
+// A plain `go build` leaves them at their development defaults.
+package buildinfo
+
+import (
+	"fmt"
+	"strings"
+
+	"nhi/basetools/pkg/requirements"
+)
+
+// Development defaults, replaced at build time
+const (
+	DevVersion = "dev"
+	unknown    = "unknown"
+)
+
+// Stamped at build time
+var (
+	Version = DevVersion // Release of the basetools toolchain, from VERSION
+	Commit  = unknown    // Git commit the binaries were built from
+	Date    = unknown    // Build time, RFC 3339 UTC
+)
+
+// IsDev reports whether the binary is a development build without a
+// version. Development builds are assumed to satisfy any constraint.
+func IsDev() bool {
+	return Version == DevVersion
+}
+
+// String describes the build for --version output, e.g.
+// "manifest 1.2.0 (commit 3f2a9c1, built 2025-05-01T12:00:00Z)".
+func String(tool string) string {
+	return fmt.Sprintf("%s %s (commit %s, built %s)", tool, Version, Commit, Date)
+}
+
+// Satisfies reports whether this build satisfies constraint, a
+// comma-separated list of comparisons such as ">=1.2" or ">=1.2, <2". A bare
+// version means >=.
+func Satisfies(constraint string) (bool, error) {
+	if err := ValidateConstraint(constraint); err != nil {
+		return false, err
+	}
+	if IsDev() {
+		return true, nil
+	}
+	return matches(Version, constraint)
+}
+
+// ValidateConstraint checks the syntax of a requires_basetools constraint.
+func ValidateConstraint(constraint string) error {
+	_, err := matches("0", constraint)
+	return err
+}
+
+// Comparison operators, longest first so ">=" is not read as ">"
+var operators = []string{">=", "<=", "==", ">", "<", "="}
+
+// matches evaluates constraint against version.
+func matches(version, constraint string) (bool, error) {
+	if strings.TrimSpace(constraint) == "" {
+		return false, fmt.Errorf("empty version constraint")
+	}
+	ok := true
+	for _, term := range strings.Split(constraint, ",") {
+		term = strings.TrimSpace(term)
+		op := ">="
+		for _, o := range operators {
+			if strings.HasPrefix(term, o) {
+				op = o
+				term = strings.TrimSpace(term[len(o):])
+				break
+			}
+		}
+		cmp, err := requirements.CompareVersions(version, term)
+		if err != nil {
+			return false, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+		switch op {
+		case ">=":
+			ok = ok && cmp >= 0
+		case "<=":
+			ok = ok && cmp <= 0
+		case ">":
+			ok = ok && cmp > 0
+		case "<":
+			ok = ok && cmp < 0
+		default:
+			ok = ok && cmp == 0
+		}
+	}
+	return ok, nil
+}
@@ -0,0 +1,65 @@
+package buildinfo
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+		wantErr    bool
+	}{
+		{version: "1.2.0", constraint: "1.2", want: true},
+		{version: "1.1.9", constraint: "1.2", want: false},
+		{version: "1.2.0", constraint: ">=1.2, <2", want: true},
+		{version: "2.0", constraint: ">=1.2, <2", want: false},
+		{version: "1.5", constraint: " > 1.4 ,<= 1.5 ", want: true},
+		{version: "1.4", constraint: ">1.4", want: false},
+		{version: "1.4.0", constraint: "==1.4", want: true},
+		{version: "1.4.1", constraint: "=1.4", want: false},
+		{version: "1.4", constraint: "<1.4", want: false},
+		{version: "1.0", constraint: "", wantErr: true},
+		{version: "1.0", constraint: ">=1.x", wantErr: true},
+		{version: "1.0", constraint: ">=1.0,", wantErr: true},
+		{version: "1.0", constraint: "~1.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.constraint, func(t *testing.T) {
+			got, err := matches(tt.version, tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	tests := []struct {
+		name       string
+		version    string
+		constraint string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "release within range", version: "1.3.0", constraint: ">=1.2, <2", want: true},
+		{name: "release too old", version: "1.1.0", constraint: ">=1.2", want: false},
+		{name: "development build satisfies anything", version: DevVersion, constraint: ">=99", want: true},
+		{name: "development build still checks the syntax", version: DevVersion, constraint: ">=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Version = tt.version
+			got, err := Satisfies(tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Satisfies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Satisfies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,4 +8,6 @@ type DiscoveredReflex struct {
 	Description string                 `json:"description"`           // Description from its manifest
 	Inputs      map[string]interface{} `json:"inputs,omitempty"`    // Input paths defined in the manifest (using interface{} for flexibility)
 	Outputs     map[string]interface{} `json:"outputs,omitempty"`   // Output paths defined in the manifest (using interface{})
+	Stdin       interface{}            `json:"stdin,omitempty"`     // Stdin contract from the manifest, if any
+	Commands    map[string]interface{} `json:"commands,omitempty"`  // Subcommands of a multi-command reflex, by name
 }
\ No newline at end of file
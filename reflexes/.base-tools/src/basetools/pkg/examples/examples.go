@@ -0,0 +1,268 @@
+// Package examples runs the examples declared in a reflex manifest and checks
+// their results. It is shared by `manifest test` and the helper's self-test.
+package examples
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// IOBaseEnv points the entrypoint helper at the directory holding the
+// input_* and output_* mounts, which is /app by default.
+const IOBaseEnv = "NHI_IO_BASE"
+
+// Runner runs manifest examples. Each example gets fresh copies of its input
+// fixtures and empty output directories in a temporary IO base.
+type Runner struct {
+	Manifest manifesttypes.Manifest
+	BaseDir  string   // Directory fixture paths are relative to
+	Fixtures string   // When set, every fixture must resolve inside this directory
+	Command  []string // Command to run, normally the helper followed by the reflex command; see Run for subcommands
+	Env      []string // Base environment; nil means the current one
+	Stderr   io.Writer
+}
+
+// Result is the outcome of one example.
+type Result struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration_ns"`
+	Failures []string      `json:"failures,omitempty"`
+}
+
+// RunAll runs every example of the manifest in order.
+func (r Runner) RunAll() []Result {
+	results := make([]Result, 0, len(r.Manifest.Examples))
+	for _, ex := range r.Manifest.Examples {
+		results = append(results, r.Run(ex))
+	}
+	return results
+}
+
+// Run runs one example and compares its exit code, stdout and output files
+// against the expectations. An example of a subcommand runs the first word
+// of Command, the helper, with the subcommand name as its only argument.
+func (r Runner) Run(ex manifesttypes.ExampleSpec) Result {
+	res := Result{Name: ex.Name}
+	fail := func(format string, args ...interface{}) {
+		res.Failures = append(res.Failures, fmt.Sprintf(format, args...))
+	}
+	if len(r.Command) == 0 {
+		fail("no command to run")
+		return res
+	}
+	command := r.Command
+	if ex.Command != "" {
+		m, err := r.Manifest.ForCommand(ex.Command)
+		if err != nil {
+			fail("%v", err)
+			return res
+		}
+		r.Manifest, command = m, []string{r.Command[0], ex.Command}
+	}
+
+	ioBase, err := r.prepare(ex)
+	if ioBase != "" {
+		defer os.RemoveAll(ioBase)
+	}
+	if err != nil {
+		fail("preparing fixtures: %v", err)
+		return res
+	}
+
+	env := r.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(append([]string(nil), env...), IOBaseEnv+"="+ioBase)
+	for _, name := range manifesttypes.SortedKeys(ex.Env) {
+		env = append(env, name+"="+ex.Env[name]) // Later entries win
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(ex.Stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = r.Stderr
+	start := time.Now()
+	err = cmd.Run()
+	res.Duration = time.Since(start)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		fail("running %s: %v", command[0], err)
+		return res
+	}
+	if res.ExitCode != ex.ExitCode {
+		fail("exit code %d, want %d", res.ExitCode, ex.ExitCode)
+	}
+
+	if ex.Stdout != nil {
+		if err := r.check(*ex.Stdout, stdout.Bytes()); err != nil {
+			fail("stdout: %v", err)
+		}
+	}
+	for _, output := range manifesttypes.SortedKeys(ex.Outputs) {
+		files := ex.Outputs[output]
+		for _, file := range manifesttypes.SortedKeys(files) {
+			actual, err := os.ReadFile(filepath.Join(ioBase, "output_"+output, file))
+			if err != nil {
+				fail("output %s/%s: %v", output, file, err)
+				continue
+			}
+			if err := r.check(files[file], actual); err != nil {
+				fail("output %s/%s: %v", output, file, err)
+			}
+		}
+	}
+
+	res.Passed = len(res.Failures) == 0
+	return res
+}
+
+// prepare creates the temporary IO base for ex: a copy of each input fixture
+// as input_<name> and an empty, world-writable output_<name> for every output
+// declared by the manifest, so the reflex can write them whichever user the
+// helper runs it as.
+func (r Runner) prepare(ex manifesttypes.ExampleSpec) (string, error) {
+	ioBase, err := os.MkdirTemp("", "nhi-example-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(ioBase, 0o755); err != nil {
+		return ioBase, err
+	}
+	for _, name := range manifesttypes.SortedKeys(ex.Inputs) {
+		if _, ok := r.Manifest.InputPaths[name]; !ok {
+			return ioBase, fmt.Errorf("input '%s' is not declared in the manifest", name)
+		}
+		src, err := r.path(ex.Inputs[name])
+		if err != nil {
+			return ioBase, fmt.Errorf("input '%s': %w", name, err)
+		}
+		if err := copyTree(src, filepath.Join(ioBase, "input_"+name)); err != nil {
+			return ioBase, fmt.Errorf("input '%s': %w", name, err)
+		}
+	}
+	for _, name := range manifesttypes.SortedKeys(r.Manifest.OutputPaths) {
+		dir := filepath.Join(ioBase, "output_"+name)
+		if err := os.Mkdir(dir, 0o777); err != nil {
+			return ioBase, err
+		}
+		if err := os.Chmod(dir, 0o777); err != nil { // Not subject to the umask
+			return ioBase, err
+		}
+	}
+	return ioBase, nil
+}
+
+// check compares actual against an expectation, loading fixture content
+// when the expectation refers to a file.
+func (r Runner) check(exp manifesttypes.Expectation, actual []byte) error {
+	expected := []byte(exp.Content)
+	if exp.File != "" {
+		path, err := r.path(exp.File)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading expected content: %w", err)
+		}
+		expected = data
+	}
+	return Match(exp.Match, expected, actual)
+}
+
+// path resolves a fixture path against the base directory and checks that
+// it stays inside the fixtures directory, if one is set.
+func (r Runner) path(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(r.BaseDir, p)
+	}
+	p = filepath.Clean(p)
+	if r.Fixtures != "" {
+		rel, err := filepath.Rel(r.Fixtures, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("fixture %s is outside %s", p, r.Fixtures)
+		}
+	}
+	return p, nil
+}
+
+// Summary returns the number of passed and failed results.
+func Summary(results []Result) (passed, failed int) {
+	for _, res := range results {
+		if res.Passed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	return passed, failed
+}
+
+// copyTree copies a fixture file or directory to dst, readable by everyone
+// so the reflex can read it whichever user it runs as. Symlinks are
+// recreated rather than followed.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			if err := os.Mkdir(target, 0o755); err != nil {
+				return err
+			}
+			return os.Chmod(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, data, 0o644); err != nil {
+				return err
+			}
+			return os.Chmod(target, 0o644)
+		default:
+			return fmt.Errorf("unsupported file type at %s", path)
+		}
+	})
+}
+
+// describe shortens content for failure messages.
+func describe(b []byte) string {
+	s := string(b)
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,104 @@
+package examples
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestRunnerRun(t *testing.T) {
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "fixtures", "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "fixtures", "src", "in.txt"), []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "fixtures", "want.txt"), []byte("ABC"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := manifesttypes.Manifest{
+		InputPaths:  map[string]manifesttypes.PathSpec{"src": {Type: "directory"}},
+		OutputPaths: map[string]manifesttypes.PathSpec{"out": {Type: "directory"}},
+	}
+	// Uppercases input_src/in.txt into output_out/in.txt, echoes GREETING and
+	// exits with EXIT_CODE
+	script := `tr a-z A-Z < "$NHI_IO_BASE/input_src/in.txt" > "$NHI_IO_BASE/output_out/in.txt"; echo "$GREETING"; exit "${EXIT_CODE:-0}"`
+	runner := Runner{Manifest: m, BaseDir: base, Fixtures: filepath.Join(base, "fixtures"), Command: []string{"sh", "-c", script}, Env: []string{"PATH=" + os.Getenv("PATH")}, Stderr: io.Discard}
+
+	example := func(edit func(*manifesttypes.ExampleSpec)) manifesttypes.ExampleSpec {
+		ex := manifesttypes.ExampleSpec{
+			Name:    "uppercase",
+			Env:     map[string]string{"GREETING": "hi"},
+			Inputs:  map[string]string{"src": "fixtures/src"},
+			Stdout:  &manifesttypes.Expectation{Content: "hi\n"},
+			Outputs: map[string]map[string]manifesttypes.Expectation{"out": {"in.txt": {File: "fixtures/want.txt"}}},
+		}
+		if edit != nil {
+			edit(&ex)
+		}
+		return ex
+	}
+	tests := []struct {
+		name         string
+		example      manifesttypes.ExampleSpec
+		wantFailures []string // Substrings of the expected failures, in order
+	}{
+		{name: "passes", example: example(nil)},
+		{
+			name:         "stdout differs",
+			example:      example(func(ex *manifesttypes.ExampleSpec) { ex.Env["GREETING"] = "bye" }),
+			wantFailures: []string{"stdout:"},
+		},
+		{
+			name:         "exit code differs",
+			example:      example(func(ex *manifesttypes.ExampleSpec) { ex.Env["EXIT_CODE"] = "3" }),
+			wantFailures: []string{"exit code 3, want 0"},
+		},
+		{
+			name:    "expected exit code",
+			example: example(func(ex *manifesttypes.ExampleSpec) { ex.Env["EXIT_CODE"] = "3"; ex.ExitCode = 3 }),
+		},
+		{
+			name: "output file missing",
+			example: example(func(ex *manifesttypes.ExampleSpec) {
+				ex.Outputs["out"]["missing.txt"] = manifesttypes.Expectation{Content: "x"}
+			}),
+			wantFailures: []string{"output out/missing.txt"},
+		},
+		{
+			name:         "undeclared input",
+			example:      example(func(ex *manifesttypes.ExampleSpec) { ex.Inputs["other"] = "fixtures/src" }),
+			wantFailures: []string{"input 'other' is not declared"},
+		},
+		{
+			name:         "input fixture outside the fixtures directory",
+			example:      example(func(ex *manifesttypes.ExampleSpec) { ex.Inputs["src"] = "fixtures/../src" }),
+			wantFailures: []string{"is outside"},
+		},
+		{
+			name: "expected file outside the fixtures directory",
+			example: example(func(ex *manifesttypes.ExampleSpec) {
+				ex.Outputs["out"]["in.txt"] = manifesttypes.Expectation{File: "/etc/hostname"}
+			}),
+			wantFailures: []string{"is outside"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runner.Run(tt.example)
+			if res.Passed != (len(tt.wantFailures) == 0) || len(res.Failures) != len(tt.wantFailures) {
+				t.Fatalf("got passed = %v, failures %q; want failures %q", res.Passed, res.Failures, tt.wantFailures)
+			}
+			for i, want := range tt.wantFailures {
+				if !strings.Contains(res.Failures[i], want) {
+					t.Errorf("failure %d = %q, want it to mention %q", i, res.Failures[i], want)
+				}
+			}
+		})
+	}
+}
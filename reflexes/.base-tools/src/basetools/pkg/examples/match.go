@@ -0,0 +1,45 @@
+package examples
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// Match compares actual content against expected using a match mode: exact
+// (the default), json or regex.
+func Match(mode string, expected, actual []byte) error {
+	switch strings.ToLower(mode) {
+	case "", manifesttypes.MatchExact:
+		if !bytes.Equal(expected, actual) {
+			return fmt.Errorf("got %s, want %s", describe(actual), describe(expected))
+		}
+	case manifesttypes.MatchJSON:
+		var want, got interface{}
+		if err := json.Unmarshal(expected, &want); err != nil {
+			return fmt.Errorf("expected content is not JSON: %v", err)
+		}
+		if err := json.Unmarshal(actual, &got); err != nil {
+			return fmt.Errorf("not JSON: %v (got %s)", err, describe(actual))
+		}
+		if !reflect.DeepEqual(want, got) {
+			return fmt.Errorf("JSON differs: got %s, want %s", describe(actual), describe(expected))
+		}
+	case manifesttypes.MatchRegex:
+		re, err := regexp.Compile(string(expected))
+		if err != nil {
+			return fmt.Errorf("invalid expected regex: %v", err)
+		}
+		if !re.Match(actual) {
+			return fmt.Errorf("got %s, which does not match /%s/", describe(actual), expected)
+		}
+	default:
+		return fmt.Errorf("unsupported match mode %q (want exact, json or regex)", mode)
+	}
+	return nil
+}
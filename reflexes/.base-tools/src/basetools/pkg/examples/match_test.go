@@ -0,0 +1,33 @@
+package examples
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected string
+		actual   string
+		wantErr  bool
+	}{
+		{name: "exact by default", expected: "hello\n", actual: "hello\n"},
+		{name: "exact differs in whitespace", mode: "exact", expected: "hello\n", actual: "hello", wantErr: true},
+		{name: "json ignores key order and formatting", mode: "json", expected: `{"a": 1, "b": [1, 2]}`, actual: "{\"b\":[1,2],\n\"a\":1}"},
+		{name: "json differs", mode: "json", expected: `{"a": 1}`, actual: `{"a": 2}`, wantErr: true},
+		{name: "json actual is not JSON", mode: "json", expected: `{}`, actual: "oops", wantErr: true},
+		{name: "json expected is not JSON", mode: "json", expected: "oops", actual: `{}`, wantErr: true},
+		{name: "regex matches", mode: "regex", expected: `^built \d+ pages$`, actual: "built 12 pages"},
+		{name: "regex does not match", mode: "regex", expected: `^built \d+ pages$`, actual: "built no pages", wantErr: true},
+		{name: "invalid regex", mode: "regex", expected: `(`, actual: "", wantErr: true},
+		{name: "mode is case insensitive", mode: "JSON", expected: `[1]`, actual: `[ 1 ]`},
+		{name: "unknown mode", mode: "fuzzy", expected: "a", actual: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Match(tt.mode, []byte(tt.expected), []byte(tt.actual))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
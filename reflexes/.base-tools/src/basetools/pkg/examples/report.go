@@ -0,0 +1,61 @@
+package examples
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// report is the JSON form of a run of examples.
+type report struct {
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Results []Result `json:"results"`
+}
+
+// WriteReport writes results to w as text (one PASS/FAIL line per example),
+// TAP version 13 or JSON.
+func WriteReport(w io.Writer, results []Result, format string) error {
+	passed, failed := Summary(results)
+	switch strings.ToLower(format) {
+	case "", "text":
+		for _, res := range results {
+			status := "PASS"
+			if !res.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "%s %s (%.2fs)\n", status, res.Name, res.Duration.Seconds())
+			for _, failure := range res.Failures {
+				fmt.Fprintf(w, "  - %s\n", failure)
+			}
+		}
+		fmt.Fprintf(w, "%d examples: %d passed, %d failed\n", len(results), passed, failed)
+	case "tap":
+		fmt.Fprintln(w, "TAP version 13")
+		fmt.Fprintf(w, "1..%d\n", len(results))
+		for i, res := range results {
+			status := "ok"
+			if !res.Passed {
+				status = "not ok"
+			}
+			fmt.Fprintf(w, "%s %d - %s\n", status, i+1, res.Name)
+			for _, failure := range res.Failures {
+				fmt.Fprintf(w, "# %s\n", failure)
+			}
+		}
+		fmt.Fprintf(w, "# passed %d, failed %d\n", passed, failed)
+	case "json":
+		if results == nil {
+			results = []Result{}
+		}
+		data, err := json.MarshalIndent(report{Passed: passed, Failed: failed, Results: results}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Fprintln(w, string(data))
+	default:
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+	return nil
+}
@@ -1,5 +1,32 @@
 package manifesttypes
 
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Constants shared between manifest tools ---
+
+// Environment variables the caller uses to identify itself. Output files are
+// handed back to this UID/GID once the reflex has finished.
+const (
+	CallingUIDEnv = "CALLING_UID"
+	CallingGIDEnv = "CALLING_GID"
+)
+
+// CommandEnv selects the subcommand of a multi-command reflex when the first
+// argument does not name one.
+const CommandEnv = "NHI_COMMAND"
+
+// Input staging modes for PathSpec.Staging
+const (
+	StagingNone = "none" // The reflex reads the mount directly
+	StagingCopy = "copy" // The helper provides a private writable copy
+)
+
 // --- Structs shared between manifest tools ---
 
 // InputSpec represents a generic input specification
@@ -7,8 +34,9 @@ type InputSpec struct {
 	Type        string `yaml:"type" json:"type"`
 	Description string `yaml:"description" json:"description"`
 	Required    bool   `yaml:"required" json:"required"`
-	Pattern     string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	Pattern     string `yaml:"pattern,omitempty" json:"pattern,omitempty"` // Shell glob the whole value must match (filepath.Match), e.g. "v[0-9]*"
 	Default     string `yaml:"default,omitempty" json:"default,omitempty"`
+	Secret      bool   `yaml:"secret,omitempty" json:"secret,omitempty"` // Value is never printed by the tools
 }
 
 // PathSpec represents a file, directory, or glob pattern specification
@@ -16,18 +44,246 @@ type PathSpec struct {
 	Type        string      `yaml:"type" json:"type"` // "file", "directory", or "glob"
 	Description string      `yaml:"description" json:"description"`
 	Required    bool        `yaml:"required" json:"required"`
-	Pattern     string      `yaml:"pattern,omitempty" json:"pattern,omitempty"` // Glob pattern or file naming pattern
-	Format      string      `yaml:"format,omitempty" json:"format,omitempty"`   // Expected content format
-	Schema      interface{} `yaml:"schema,omitempty" json:"schema,omitempty"`   // Optional schema for validation
+	Pattern     string      `yaml:"pattern,omitempty" json:"pattern,omitempty"`   // Glob pattern or file naming pattern
+	Format      string      `yaml:"format,omitempty" json:"format,omitempty"`     // Expected content format
+	Schema      interface{} `yaml:"schema,omitempty" json:"schema,omitempty"`     // Optional schema for validation
+	Staging     string      `yaml:"staging,omitempty" json:"staging,omitempty"`   // Input staging mode: "none" (default) or "copy"
+	MaxSize     string      `yaml:"max_size,omitempty" json:"max_size,omitempty"` // Size cap for stdin, e.g. "10M"
+}
+
+// RuntimeSpec holds settings that control how the entrypoint helper executes the reflex
+type RuntimeSpec struct {
+	AllowRoot         bool   `yaml:"allow_root,omitempty" json:"allow_root,omitempty"`                         // Permit the reflex itself to run as root
+	StageOutputs      bool   `yaml:"stage_outputs,omitempty" json:"stage_outputs,omitempty"`                   // Publish outputs only after a successful run
+	KeepFailedOutputs bool   `yaml:"keep_failed_outputs,omitempty" json:"keep_failed_outputs,omitempty"`       // Keep staged outputs of failed runs under .failed-<runid>
+	Timeout           string `yaml:"timeout,omitempty" json:"timeout,omitempty"`                               // Maximum run time, e.g. "10m"
+	TmpDirMaxSize     string `yaml:"tmpdir_max_size,omitempty" json:"tmpdir_max_size,omitempty"`               // Size cap for NHI_TMPDIR, e.g. "512M"
+	KeepTmpOnFailure  bool   `yaml:"keep_tmpdir_on_failure,omitempty" json:"keep_tmpdir_on_failure,omitempty"` // Keep the run workspace when the run fails
+	StrictEnvironment bool   `yaml:"strict_environment,omitempty" json:"strict_environment,omitempty"`         // Reject environment values that break their type or pattern
+}
+
+// ExecutableRequirement is a program the reflex needs in its image, with an
+// optional minimum version.
+type ExecutableRequirement struct {
+	Name           string   `yaml:"name" json:"name"`                                           // Looked up in PATH, or an absolute path
+	MinVersion     string   `yaml:"min_version,omitempty" json:"min_version,omitempty"`         // Dotted version, e.g. "18.2"
+	VersionCommand []string `yaml:"version_command,omitempty" json:"version_command,omitempty"` // Prints the version; defaults to <name> --version
+	VersionPattern string   `yaml:"version_pattern,omitempty" json:"version_pattern,omitempty"` // Regex whose first group is the version; defaults to the first number
+}
+
+// RequiresSpec lists the prerequisites the reflex's image must provide. The
+// entrypoint helper checks them before running the reflex.
+type RequiresSpec struct {
+	Executables []ExecutableRequirement `yaml:"executables,omitempty" json:"executables,omitempty"`
+	Files       []string                `yaml:"files,omitempty" json:"files,omitempty"` // Files or directories that must exist
+}
+
+// Failure policies for HookSpec.OnFailure
+const (
+	HookAbort  = "abort"  // Stop the run: later hooks and, after a pre hook, the command are skipped (default)
+	HookFail   = "fail"   // Carry on, but fail the run
+	HookIgnore = "ignore" // Carry on; the failure is only reported
+)
+
+// HookSpec is a step the entrypoint helper runs before or after the reflex
+// command, with the same environment, identity and workspace.
+type HookSpec struct {
+	Name      string   `yaml:"name" json:"name"`
+	Command   []string `yaml:"command" json:"command"`
+	OnFailure string   `yaml:"on_failure,omitempty" json:"on_failure,omitempty"` // "abort" (default), "fail" or "ignore"
+	Timeout   string   `yaml:"timeout,omitempty" json:"timeout,omitempty"`       // Defaults to the run's timeout
+}
+
+// HooksSpec lists the hooks run, in order, before and after the command.
+type HooksSpec struct {
+	Pre  []HookSpec `yaml:"pre,omitempty" json:"pre,omitempty"`
+	Post []HookSpec `yaml:"post,omitempty" json:"post,omitempty"` // Run only while the run is still successful
+}
+
+// Stdin formats the entrypoint helper validates. Any other format is only
+// checked for presence and size.
+const (
+	FormatJSON      = "json"  // A single JSON document, buffered and validated before the run
+	FormatJSONLines = "jsonl" // One JSON document per line, validated as it is streamed
+)
+
+// IsJSONLines reports whether format names JSON Lines under one of its
+// common spellings.
+func IsJSONLines(format string) bool {
+	switch strings.ToLower(format) {
+	case FormatJSONLines, "ndjson", "json-lines", "jsonlines":
+		return true
+	}
+	return false
+}
+
+// Match modes for Expectation.Match
+const (
+	MatchExact = "exact" // Byte-for-byte equality (default)
+	MatchJSON  = "json"  // Semantic JSON equality, ignoring formatting and key order
+	MatchRegex = "regex" // The expected content is a regular expression
+)
+
+// Expectation describes the expected content of stdout or of an output file.
+// The content is given inline or, with File, read from a fixture relative to
+// the manifest directory.
+type Expectation struct {
+	Match   string `yaml:"match,omitempty" json:"match,omitempty"`     // "exact" (default), "json" or "regex"
+	Content string `yaml:"content,omitempty" json:"content,omitempty"` // Expected content
+	File    string `yaml:"file,omitempty" json:"file,omitempty"`       // Fixture holding the expected content
+}
+
+// ExampleSpec is a documented invocation of the reflex that doubles as a
+// regression test. Fixture paths are relative to the manifest directory.
+type ExampleSpec struct {
+	Name        string                            `yaml:"name" json:"name"`
+	Description string                            `yaml:"description,omitempty" json:"description,omitempty"`
+	Env         map[string]string                 `yaml:"env,omitempty" json:"env,omitempty"`             // Environment values
+	Inputs      map[string]string                 `yaml:"inputs,omitempty" json:"inputs,omitempty"`       // Input name -> fixture path
+	Command     string                            `yaml:"command,omitempty" json:"command,omitempty"`     // Subcommand to run, for multi-command reflexes
+	Stdin       string                            `yaml:"stdin,omitempty" json:"stdin,omitempty"`         // Content piped to the reflex
+	ExitCode    int                               `yaml:"exit_code,omitempty" json:"exit_code,omitempty"` // Expected exit code
+	Stdout      *Expectation                      `yaml:"stdout,omitempty" json:"stdout,omitempty"`
+	Outputs     map[string]map[string]Expectation `yaml:"outputs,omitempty" json:"outputs,omitempty"` // Output name -> file within it -> expectation
+}
+
+// CommandSpec is one subcommand of a multi-command reflex. Its sections are
+// added to the manifest's top-level ones, which all subcommands share.
+type CommandSpec struct {
+	Description string               `yaml:"description,omitempty" json:"description,omitempty"`
+	Command     []string             `yaml:"command" json:"command"`
+	Environment map[string]InputSpec `yaml:"environment,omitempty" json:"environment,omitempty"`
+	InputPaths  map[string]PathSpec  `yaml:"input_paths,omitempty" json:"input_paths,omitempty"`
+	Stdin       *PathSpec            `yaml:"stdin,omitempty" json:"stdin,omitempty"`
+	Stdout      *PathSpec            `yaml:"stdout,omitempty" json:"stdout,omitempty"`
+	OutputPaths map[string]PathSpec  `yaml:"output_paths,omitempty" json:"output_paths,omitempty"`
+	Outputs     map[string]InputSpec `yaml:"outputs,omitempty" json:"outputs,omitempty"`
 }
 
 // Manifest represents the structure of a reflex manifest
 type Manifest struct {
-	Name        string              `yaml:"name" json:"name"`
-	Version     string              `yaml:"version" json:"version"`
-	Description string              `yaml:"description" json:"description"`
-	Environment map[string]InputSpec `yaml:"environment" json:"environment"`
-	InputPaths  map[string]PathSpec `yaml:"input_paths,omitempty" json:"input_paths,omitempty"`
-	Stdout      *PathSpec           `yaml:"stdout,omitempty" json:"stdout,omitempty"`
-	OutputPaths map[string]PathSpec `yaml:"output_paths,omitempty" json:"output_paths,omitempty"`
-}
\ No newline at end of file
+	Name              string                 `yaml:"name" json:"name"`
+	Version           string                 `yaml:"version" json:"version"`
+	Description       string                 `yaml:"description" json:"description"`
+	RequiresBasetools string                 `yaml:"requires_basetools,omitempty" json:"requires_basetools,omitempty"` // Basetools versions the manifest needs, e.g. ">=1.2"
+	Environment       map[string]InputSpec   `yaml:"environment" json:"environment"`
+	InputPaths        map[string]PathSpec    `yaml:"input_paths,omitempty" json:"input_paths,omitempty"`
+	Stdin             *PathSpec              `yaml:"stdin,omitempty" json:"stdin,omitempty"` // What the reflex reads from stdin; unchecked when absent
+	Stdout            *PathSpec              `yaml:"stdout,omitempty" json:"stdout,omitempty"`
+	OutputPaths       map[string]PathSpec    `yaml:"output_paths,omitempty" json:"output_paths,omitempty"`
+	Outputs           map[string]InputSpec   `yaml:"outputs,omitempty" json:"outputs,omitempty"` // Scalar key/value outputs written to $NHI_OUTPUTS
+	Runtime           *RuntimeSpec           `yaml:"runtime,omitempty" json:"runtime,omitempty"`
+	Command           []string               `yaml:"command,omitempty" json:"command,omitempty"`   // Command the image runs through the helper
+	Requires          *RequiresSpec          `yaml:"requires,omitempty" json:"requires,omitempty"` // Prerequisites checked before the command runs
+	Hooks             *HooksSpec             `yaml:"hooks,omitempty" json:"hooks,omitempty"`       // Steps run around the command
+	Commands          map[string]CommandSpec `yaml:"commands,omitempty" json:"commands,omitempty"` // Subcommands, selected by the first argument or NHI_COMMAND
+	Examples          []ExampleSpec          `yaml:"examples,omitempty" json:"examples,omitempty"`
+}
+
+// AllowsRoot reports whether the manifest permits the reflex to run as root.
+func (m Manifest) AllowsRoot() bool {
+	return m.Runtime != nil && m.Runtime.AllowRoot
+}
+
+// ForCommand returns the manifest as it applies to the subcommand name. The
+// subcommand's environment, paths and scalar outputs are added to the
+// top-level ones, replacing entries of the same name; its stdin and stdout
+// contracts replace the top-level ones when set. Command becomes the
+// subcommand's command line.
+func (m Manifest) ForCommand(name string) (Manifest, error) {
+	spec, ok := m.Commands[name]
+	if !ok {
+		return m, fmt.Errorf("unknown command %q", name)
+	}
+	out := m
+	out.Command = spec.Command
+	out.Environment = mergeSections(m.Environment, spec.Environment)
+	out.InputPaths = mergeSections(m.InputPaths, spec.InputPaths)
+	out.OutputPaths = mergeSections(m.OutputPaths, spec.OutputPaths)
+	out.Outputs = mergeSections(m.Outputs, spec.Outputs)
+	if spec.Stdin != nil {
+		out.Stdin = spec.Stdin
+	}
+	if spec.Stdout != nil {
+		out.Stdout = spec.Stdout
+	}
+	return out, nil
+}
+
+// mergeSections returns base with the entries of over added, without
+// modifying either.
+func mergeSections[V any](base, over map[string]V) map[string]V {
+	if len(over) == 0 {
+		return base
+	}
+	out := make(map[string]V, len(base)+len(over))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range over {
+		out[k] = v
+	}
+	return out
+}
+
+// SortedKeys returns the keys of m in lexical order. Manifest sections are
+// maps, so every rendering and check iterates them through SortedKeys to
+// produce the same output on every run.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateValue checks a value against the spec's declared type and pattern.
+// Unknown types are accepted as strings. Error messages never include the
+// value itself, since it may be secret.
+func (s InputSpec) ValidateValue(value string) error {
+	switch strings.ToLower(s.Type) {
+	case "boolean", "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("expected a boolean (true or false)")
+		}
+	case "integer", "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+	case "number", "float":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("expected a number")
+		}
+	}
+	if s.Pattern != "" {
+		matched, err := filepath.Match(s.Pattern, value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern in manifest: %v", err)
+		}
+		if !matched {
+			return fmt.Errorf("value does not match required pattern: %s", s.Pattern)
+		}
+	}
+	return nil
+}
+
+// TypedValue converts a value that passed ValidateValue to the JSON value of
+// the spec's declared type: a bool, an int64, a float64 or a string.
+func (s InputSpec) TypedValue(value string) interface{} {
+	switch strings.ToLower(s.Type) {
+	case "boolean", "bool":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "integer", "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "number", "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
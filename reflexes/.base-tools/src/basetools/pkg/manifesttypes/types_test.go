@@ -0,0 +1,70 @@
+package manifesttypes
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"nhi/basetools/internal/testutil"
+)
+
+func TestValidateValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    InputSpec
+		value   string
+		wantErr bool
+	}{
+		{name: "string", spec: InputSpec{Type: "string"}, value: "anything"},
+		{name: "unknown type is a string", spec: InputSpec{Type: "color"}, value: "red"},
+		{name: "boolean", spec: InputSpec{Type: "boolean"}, value: "true"},
+		{name: "not a boolean", spec: InputSpec{Type: "boolean"}, value: "maybe", wantErr: true},
+		{name: "integer", spec: InputSpec{Type: "integer"}, value: "-42"},
+		{name: "not an integer", spec: InputSpec{Type: "integer"}, value: "4.2", wantErr: true},
+		{name: "number", spec: InputSpec{Type: "number"}, value: "4.2"},
+		{name: "not a number", spec: InputSpec{Type: "number"}, value: "four", wantErr: true},
+		{name: "matches glob pattern", spec: InputSpec{Pattern: "v[0-9]*"}, value: "v12"},
+		{name: "does not match glob pattern", spec: InputSpec{Pattern: "v[0-9]*"}, value: "12", wantErr: true},
+		{name: "type and pattern", spec: InputSpec{Type: "integer", Pattern: "1*"}, value: "100"},
+		{name: "invalid pattern", spec: InputSpec{Pattern: "[a-"}, value: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.spec.ValidateValue(tt.value); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateValue(%q) = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// The shared fixture's contract accepts the values its help output advertises.
+func TestFixtureEnvironmentValues(t *testing.T) {
+	data, err := os.ReadFile(testutil.Fixture("manifest.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "TARGET_FORMAT", value: "png"},
+		{name: "TARGET_FORMAT", value: "webp"},
+		{name: "TARGET_FORMAT", value: "PNG", wantErr: true},
+		{name: "QUALITY", value: "85"},
+		{name: "QUALITY", value: "high", wantErr: true},
+		{name: "VERBOSE", value: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			if err := m.Environment[tt.name].ValidateValue(tt.value); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateValue(%q) = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
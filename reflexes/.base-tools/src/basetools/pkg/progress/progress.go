@@ -0,0 +1,133 @@
+// Package progress implements the side channel reflexes use to report
+// progress to their caller. The entrypoint helper opens a pipe and exports
+// its file descriptor as NHI_PROGRESS_FD; the reflex writes one JSON event
+// per line to it. Reporting is best effort: outside the helper the
+// descriptor is not set and reporting does nothing.
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// FDEnv names the variable holding the file descriptor events are written to.
+const FDEnv = "NHI_PROGRESS_FD"
+
+// MaxEventSize is the largest encoded event, newline included. Pipe writes up
+// to this size are atomic, so events from concurrent writers never interleave.
+const MaxEventSize = 4096
+
+// Event is one progress report. At least one field must be set.
+type Event struct {
+	Phase   string   `json:"phase,omitempty"`   // Short name of the current step, e.g. "render"
+	Percent *float64 `json:"percent,omitempty"` // Overall completion, 0 to 100
+	Message string   `json:"message,omitempty"` // Human-readable detail
+}
+
+// At returns an event for phase at percent with message.
+func At(phase string, percent float64, message string) Event {
+	return Event{Phase: phase, Percent: &percent, Message: message}
+}
+
+// Validate checks that the event carries something and that its percent is
+// within range.
+func (e Event) Validate() error {
+	if e.Phase == "" && e.Percent == nil && e.Message == "" {
+		return errors.New("event has no phase, percent or message")
+	}
+	if e.Percent != nil && (*e.Percent < 0 || *e.Percent > 100) {
+		return fmt.Errorf("percent %v is outside 0-100", *e.Percent)
+	}
+	return nil
+}
+
+// Encode renders the event as one line of JSON.
+func (e Event) Encode() ([]byte, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if len(data) > MaxEventSize {
+		return nil, fmt.Errorf("event is %d bytes, over the limit of %d", len(data), MaxEventSize)
+	}
+	return data, nil
+}
+
+// Decode parses and validates one line written by a reporter.
+func Decode(line []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, fmt.Errorf("invalid progress event: %w", err)
+	}
+	if err := e.Validate(); err != nil {
+		return e, fmt.Errorf("invalid progress event: %w", err)
+	}
+	return e, nil
+}
+
+// Scan reads events from r until it ends, calling fn for each non-empty line
+// with the decoded event or the reason it is invalid.
+func Scan(r io.Reader, fn func(line []byte, e Event, err error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, MaxEventSize), MaxEventSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		e, err := Decode(line)
+		fn(line, e, err)
+	}
+	return scanner.Err()
+}
+
+// Reporter writes events to the progress channel. A nil Reporter discards
+// them, so reflexes can report unconditionally.
+type Reporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewReporter returns a reporter writing to w.
+func NewReporter(w io.Writer) *Reporter {
+	return &Reporter{w: w}
+}
+
+// FromEnv returns a reporter for the descriptor in NHI_PROGRESS_FD, or nil
+// when it is not set.
+func FromEnv() (*Reporter, error) {
+	v := os.Getenv(FDEnv)
+	if v == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(v)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("%s: invalid file descriptor %q", FDEnv, v)
+	}
+	return NewReporter(os.NewFile(uintptr(fd), "progress")), nil
+}
+
+// Report writes one event in a single write.
+func (r *Reporter) Report(e Event) error {
+	if r == nil {
+		return nil
+	}
+	data, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(data)
+	return err
+}
@@ -0,0 +1,162 @@
+// Package requirements checks the prerequisites a reflex manifest declares
+// under requires: executables, with optional minimum versions, and files.
+// It is shared by the entrypoint helper, which checks them before running
+// the reflex, and by `manifest verify`.
+package requirements
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+// DefaultVersionPattern finds the version in the output of a version
+// command: the first number, dotted or not, such as 18.2.0 in "v18.2.0" or
+// 9 in "tool 9".
+const DefaultVersionPattern = `(\d+(?:\.\d+)*)`
+
+// How long a version command may run
+const versionTimeout = 10 * time.Second
+
+// Problem is one missing or outdated prerequisite.
+type Problem struct {
+	Requirement string `json:"requirement"` // Executable name or file path
+	Reason      string `json:"reason"`      // What is wrong with it
+}
+
+func (p Problem) Error() string {
+	return p.Requirement + ": " + p.Reason
+}
+
+// Check checks every prerequisite in spec and returns all problems, in
+// manifest order, so they can be reported at once. A nil spec has none.
+func Check(spec *manifesttypes.RequiresSpec) []Problem {
+	if spec == nil {
+		return nil
+	}
+	var problems []Problem
+	for _, req := range spec.Executables {
+		if reason := checkExecutable(req); reason != "" {
+			problems = append(problems, Problem{Requirement: req.Name, Reason: reason})
+		}
+	}
+	for _, path := range spec.Files {
+		if _, err := os.Stat(path); err != nil {
+			reason := err.Error()
+			if os.IsNotExist(err) {
+				reason = "does not exist"
+			}
+			problems = append(problems, Problem{Requirement: path, Reason: reason})
+		}
+	}
+	return problems
+}
+
+// checkExecutable returns the reason an executable requirement is not met, or
+// the empty string.
+func checkExecutable(req manifesttypes.ExecutableRequirement) string {
+	if req.Name == "" {
+		return "executable requirement without a name"
+	}
+	if _, err := exec.LookPath(req.Name); err != nil {
+		return "not found in PATH"
+	}
+	if req.MinVersion == "" {
+		return ""
+	}
+	version, err := Version(req)
+	if err != nil {
+		return err.Error()
+	}
+	cmp, err := CompareVersions(version, req.MinVersion)
+	if err != nil {
+		return err.Error()
+	}
+	if cmp < 0 {
+		return fmt.Sprintf("version %s is older than the required %s", version, req.MinVersion)
+	}
+	return ""
+}
+
+// Version runs the requirement's version command and extracts the version
+// from its output.
+func Version(req manifesttypes.ExecutableRequirement) (string, error) {
+	command := req.VersionCommand
+	if len(command) == 0 {
+		command = []string{req.Name, "--version"}
+	}
+	pattern := req.VersionPattern
+	if pattern == "" {
+		pattern = DefaultVersionPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid version_pattern: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running %s: %v", strings.Join(command, " "), err)
+	}
+	m := re.FindStringSubmatch(string(out))
+	switch {
+	case m == nil:
+		return "", fmt.Errorf("no version matching %s in the output of %s", pattern, strings.Join(command, " "))
+	case len(m) > 1:
+		return m[1], nil
+	default:
+		return m[0], nil
+	}
+}
+
+// CompareVersions compares two dotted versions numerically, returning -1, 0
+// or 1. Missing components count as 0, so 18.2 equals 18.2.0.
+func CompareVersions(a, b string) (int, error) {
+	pa, err := versionParts(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := versionParts(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// versionParts splits a dotted version, ignoring a leading "v".
+func versionParts(v string) ([]int, error) {
+	fields := strings.Split(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".")
+	parts := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
@@ -0,0 +1,65 @@
+package requirements
+
+import (
+	"testing"
+
+	"nhi/basetools/pkg/manifesttypes"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "18.2", b: "18.2.0", want: 0},
+		{a: "v18.2.1", b: "18.2", want: 1},
+		{a: "1.10", b: "1.9", want: 1},
+		{a: "2", b: "10", want: -1},
+		{a: "9", b: "9.0.1", want: -1},
+		{a: " 3.12 ", b: "3.12", want: 0},
+		{a: "1.2-beta", b: "1.2", wantErr: true},
+		{a: "1.2", b: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			got, err := CompareVersions(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompareVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("CompareVersions() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{name: "dotted version", output: "node v18.2.0", want: "18.2.0"},
+		{name: "single number", output: "tool 9", want: "9"},
+		{name: "first number wins", output: "ruby 3.2.2 (2023-03-30 revision e51014f9c0)", want: "3.2.2"},
+		{name: "custom pattern", output: "go version go1.21.5 linux/amd64", pattern: `go(\d+(?:\.\d+)+)`, want: "1.21.5"},
+		{name: "pattern without a group", output: "version 4.5", pattern: `\d+\.\d+`, want: "4.5"},
+		{name: "no version", output: "unknown", wantErr: true},
+		{name: "invalid pattern", output: "1.0", pattern: `(`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := manifesttypes.ExecutableRequirement{Name: "sh", VersionCommand: []string{"echo", tt.output}, VersionPattern: tt.pattern}
+			got, err := Version(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Version() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Version() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Package schema validates decoded JSON values against the schemas embedded
+// in reflex manifests. It implements the subset of JSON Schema that
+// manifests use: type, enum, const, properties, required,
+// additionalProperties, items, minItems/maxItems, minLength/maxLength,
+// minimum/maximum and pattern. Other keywords, such as $schema, description
+// or format, are accepted and ignored.
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validate checks value, as decoded by encoding/json, against schema, as
+// decoded from YAML or JSON. A nil schema accepts everything. The error
+// names the location of the first violation, e.g. "$.items[2].name".
+func Validate(schema interface{}, value interface{}) error {
+	return validate(schema, value, "$")
+}
+
+func validate(schema interface{}, value interface{}, at string) error {
+	switch s := schema.(type) {
+	case nil:
+		return nil
+	case bool:
+		if !s {
+			return fmt.Errorf("%s: no value is allowed here", at)
+		}
+		return nil
+	}
+	s, ok := asMap(schema)
+	if !ok {
+		return fmt.Errorf("%s: invalid schema: expected an object, got %T", at, schema)
+	}
+
+	if t, ok := s["type"]; ok {
+		if err := checkType(t, value, at); err != nil {
+			return err
+		}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if equal(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed values", at)
+		}
+	}
+	if c, ok := s["const"]; ok && !equal(c, value) {
+		return fmt.Errorf("%s: value does not equal the required constant", at)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return validateObject(s, v, at)
+	case []interface{}:
+		if n, ok := number(s["minItems"]); ok && float64(len(v)) < n {
+			return fmt.Errorf("%s: expected at least %v items, got %d", at, n, len(v))
+		}
+		if n, ok := number(s["maxItems"]); ok && float64(len(v)) > n {
+			return fmt.Errorf("%s: expected at most %v items, got %d", at, n, len(v))
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := number(s["minLength"]); ok && length < n {
+			return fmt.Errorf("%s: expected at least %v characters", at, n)
+		}
+		if n, ok := number(s["maxLength"]); ok && length > n {
+			return fmt.Errorf("%s: expected at most %v characters", at, n)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern in schema: %v", at, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: value does not match pattern %s", at, p)
+			}
+		}
+	case float64:
+		if n, ok := number(s["minimum"]); ok && v < n {
+			return fmt.Errorf("%s: expected a value of at least %v", at, n)
+		}
+		if n, ok := number(s["maximum"]); ok && v > n {
+			return fmt.Errorf("%s: expected a value of at most %v", at, n)
+		}
+	}
+	return nil
+}
+
+// validateObject applies the object keywords. Properties are checked in
+// sorted order so the first reported violation is stable.
+func validateObject(s map[string]interface{}, v map[string]interface{}, at string) error {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+	}
+	props, _ := asMap(s["properties"])
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub, declared := props[name]
+		if !declared {
+			sub, declared = s["additionalProperties"]
+			if !declared {
+				continue
+			}
+		}
+		if err := validate(sub, v[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkType checks value against a type name or a list of type names.
+func checkType(t interface{}, value interface{}, at string) error {
+	var names []string
+	switch tt := t.(type) {
+	case string:
+		names = []string{tt}
+	case []interface{}:
+		for _, n := range tt {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	default:
+		return fmt.Errorf("%s: invalid schema: type must be a string or a list", at)
+	}
+	actual := typeOf(value)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", at, strings.Join(names, " or "), actual)
+}
+
+// typeOf returns the JSON Schema type of a decoded value.
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// asMap returns a schema object, whichever decoder produced it.
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// number converts a numeric schema value, which YAML may decode as an int.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// equal compares a schema value with a decoded value, treating numbers of
+// any Go type alike.
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -23,16 +23,26 @@ SITE_DEST_DIR="${OUTPUT_STATIC_SITE}"   # Use var without _DIR
 # --- Build Logic --- #
 
 # --- Setup Temporary Build Directory --- #
-BUILD_DIR="/tmp/jekyll_build_$$_${RANDOM}" # Temporary, writable build directory
+# NHI_TMPDIR is a per-run workspace created (and removed) by the entrypoint helper
+WORK_DIR="${NHI_TMPDIR:-/tmp}"
+BUILD_DIR="${WORK_DIR}/jekyll_build" # Temporary, writable build directory
 echo "Setting up temporary build directory: ${BUILD_DIR}"
 mkdir -p "${BUILD_DIR}" # Create build dir (assets subdir not needed early anymore)
 
-# --- Tailwind CSS Build (Outputting to /tmp first) ---
+# Progress is best effort: never fail the build over it
+progress() {
+  if command -v nhi-progress >/dev/null 2>&1; then
+    nhi-progress "$@" || true
+  fi
+}
+
+# --- Tailwind CSS Build (Outputting to the temporary workspace first) ---
 echo "Building Tailwind CSS..."
+progress --phase tailwind --percent 10 "Building Tailwind CSS"
 TAILWIND_CONFIG_DIR="/app/tailwind_build_config"
 TAILWIND_INPUT="${TAILWIND_CONFIG_DIR}/input.css"
 # TAILWIND_OUTPUT_DIR="${BUILD_DIR}/assets" # Old - output to build dir
-TAILWIND_TMP_OUTPUT_FILE="/tmp/tailwind.css" # New - output to temp file
+TAILWIND_TMP_OUTPUT_FILE="${WORK_DIR}/tailwind.css" # New - output to temp file
 
 # Ensure the Tailwind config dir exists (should be copied by Dockerfile)
 if [ ! -d "${TAILWIND_CONFIG_DIR}" ]; then
@@ -62,8 +72,9 @@ fi
 echo "Tailwind CSS build successful. Temporary output at ${TAILWIND_TMP_OUTPUT_FILE}"
 # --- End Tailwind CSS Build ---
 
+progress --phase prepare --percent 40 "Preparing the build directory"
 THEME_DIR="/app/themes/default/blog" # Path to the baked-in theme
-# BUILD_DIR="/tmp/jekyll_build_$$_${RANDOM}" # Defined earlier
+# BUILD_DIR="${WORK_DIR}/jekyll_build" # Defined earlier
 
 # echo "Setting up temporary build directory: ${BUILD_DIR}" # Done earlier
 # mkdir -p "${BUILD_DIR}" # Done earlier
@@ -136,6 +147,7 @@ fi
 
 # --- Build Step (Source is BUILD_DIR) ---
 echo "Running Jekyll build..."
+progress --phase render --percent 60 "Running Jekyll build"
 # Ensure SITE_DEST_DIR exists (Helper validates, but good practice)
 mkdir -p "${SITE_DEST_DIR}"
 
@@ -145,21 +157,12 @@ export BUNDLE_GEMFILE="/app/Gemfile"
 echo "Using config files: ${CONFIG_STRING}"
 JEKYLL_ENV=production bundle exec jekyll build --source "${BUILD_DIR}" --destination "${SITE_DEST_DIR}" --config "${CONFIG_STRING}"
 
-# --- Copy Tailwind CSS from Temp to Final Destination --- #
-FINAL_CSS_DIR="${SITE_DEST_DIR}/assets"
-FINAL_CSS_FILE="${FINAL_CSS_DIR}/tailwind.css"
-echo "Copying Tailwind CSS from ${TAILWIND_TMP_OUTPUT_FILE} to ${FINAL_CSS_FILE}"
-mkdir -p "${FINAL_CSS_DIR}"
-cp "${TAILWIND_TMP_OUTPUT_FILE}" "${FINAL_CSS_FILE}"
-# Optional: remove temp file
-# rm -f "${TAILWIND_TMP_OUTPUT_FILE}"
-# --- End Copy Tailwind CSS --- #
-
-# Clean up temporary build directory (optional)
-# echo "Cleaning up ${BUILD_DIR}"
-# rm -rf "${BUILD_DIR}"
+# The Tailwind CSS is copied from NHI_TMPDIR into the site by the
+# copy-tailwind-css post hook in manifest.yml. The temporary build directory
+# and CSS are removed by the entrypoint helper once the hooks have run.
 
 echo "Jekyll build complete. Output in ${SITE_DEST_DIR}"
+progress --phase render --percent 90 "Jekyll build complete"
 
 # --- Ownership --- (Handled by entrypoint/runner)
 
@@ -29,4 +29,12 @@ for i in range(max_len):
         interleaved += reversed_text[i]
 
 # Print the strangely interleaved result to stdout
-print(interleaved)
\ No newline at end of file
+print(interleaved)
+
+# Save the result to the 'result' output as well. The entrypoint helper
+# hands the file to CALLING_UID/CALLING_GID once the reflex has exited, so
+# the reflex never changes ownership itself.
+output_dir = os.environ.get('OUTPUT_RESULT')
+if output_dir and os.path.isdir(output_dir):
+    with open(os.path.join(output_dir, 'result.txt'), 'w') as f:
+        f.write(interleaved + '\n')
\ No newline at end of file
@@ -4,7 +4,6 @@ import os
 import json
 from datetime import datetime
 from pathlib import Path
-import sys
 
 def process_input(text: str, uppercase: bool) -> str:
     """Process the input text according to the parameters."""
@@ -12,17 +11,6 @@ def process_input(text: str, uppercase: bool) -> str:
         text = text.upper()
     return text
 
-def ensure_output_ownership(path: Path):
-    """Ensure the file is owned by the calling user if UID/GID are provided."""
-    uid = os.environ.get('CALLING_UID')
-    gid = os.environ.get('CALLING_GID')
-
-    if uid is not None and gid is not None:
-        try:
-            os.chown(path, int(uid), int(gid))
-        except (ValueError, OSError) as e:
-            print(f"Warning: Could not change ownership of {path}: {e}", file=sys.stderr)
-
 def main():
     # Get environment variables
     input_text = os.environ["INPUT_TEXT"]
@@ -42,8 +30,8 @@ def main():
     # Write to output file
     output_file = Path("/output/result.txt")
     output_file.parent.mkdir(parents=True, exist_ok=True)
+    # The entrypoint helper hands the file to CALLING_UID/CALLING_GID
     output_file.write_text(result)
-    ensure_output_ownership(output_file)
 
     # Output JSON to stdout
     output = {